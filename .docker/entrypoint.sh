@@ -5,6 +5,7 @@ until PGHOST=${HOST_DB} PGDATABASE=${POSTGRES_DB} PGPORT=${PORT_DB} PGUSER=${POS
 migrate -source file://${PWD}/migrations/ -database postgres://${POSTGRES_USER}:${POSTGRES_PASSWORD}@${HOST_DB}:${PORT_DB}/${POSTGRES_DB}?sslmode=disable up
 
 go get -d ./...
-go run -race ./cmd/main.go
+go build -race -o /tmp/app ./cmd/main.go
 
-eval "$@"
+# exec replaces the shell, so SIGTERM from docker reaches the service and it shuts down gracefully
+exec /tmp/app "$@"
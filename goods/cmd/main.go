@@ -4,28 +4,117 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/Shopify/sarama"
 	"github.com/kybuk_oo/example_go_metrics/goods/pkg/broker"
-	"github.com/kybuk_oo/example_go_metrics/goods/pkg/datastore"
+	"github.com/kybuk_oo/example_go_metrics/goods/pkg/monitoring"
 	"github.com/kybuk_oo/example_go_metrics/goods/transport"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/datastore"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/kybuk_oo/example_go_metrics/platform/shutdown"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultRetentionInterval = 10 * time.Minute
+	healthWatchInterval      = 15 * time.Second
+)
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx, "goods")
+	if err != nil {
+		log.Error().Err(err).Msg("Tracing hasn't been started.")
+	}
+
 	db := datastore.InitDB()
-	producer := broker.InitKafkaProducer()
+	kafkaCfg, err := kafka.LoadClientConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Kafka client hasn't been configured.")
+		os.Exit(1)
+	}
+	producer, kafkaClient := kafka.InitProducer(kafkaCfg)
 
-	handlers := map[string]sarama.ConsumerGroupHandler{
-		os.Getenv("ORDER_CREATED_TOPIC"): broker.BuildOrderCreatedHandler(db, producer),
+	metrics, err := monitoring.StartMetrics()
+	if err != nil {
+		log.Error().Err(err).Msg("Server mertrics hasn't been started.")
+		os.Exit(1)
 	}
-	broker.RunConsumers(context.Background(), handlers)
 
-	server := transport.NewServer()
-	fmt.Println("server is starting...")
-	err := server.Start()
+	processed := inbox.New(metrics.Metrics)
+	handlers := map[string]kafka.MessageHandler{
+		os.Getenv("ORDER_CREATED_TOPIC"):   broker.BuildOrderCreatedHandler(db, producer, metrics, processed, events.SchemaOrderCreatedV1),
+		os.Getenv("ORDER_CANCELLED_TOPIC"): broker.BuildOrderCancelledHandler(db, processed),
+	}
+	if topic := os.Getenv("ORDER_CREATED_V2_TOPIC"); topic != "" {
+		handlers[topic] = broker.BuildOrderCreatedHandler(db, producer, metrics, processed, events.SchemaOrderCreatedV2)
+	}
+	retryHandlers, err := kafka.BuildRetryHandlers(handlers, kafka.NewRetryPolicy(), producer, metrics.Metrics)
 	if err != nil {
+		log.Error().Err(err).Msg("Consumers haven't been configured.")
+		os.Exit(1)
+	}
+	consumers, err := kafka.RunConsumers(ctx, kafkaCfg, retryHandlers)
+	if err != nil {
+		log.Error().Err(err).Msg("Consumers haven't been started.")
+		os.Exit(1)
+	}
+
+	var background sync.WaitGroup
+	cleaner := retention.NewCleaner(db, metrics.Metrics, inbox.RetentionPolicy(), broker.ReservationRetentionPolicy())
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		cleaner.Run(ctx, config.Duration("RETENTION_INTERVAL", defaultRetentionInterval))
+	}()
+
+	checker := health.NewChecker(metrics.GaugeVec["dependency_up"])
+	checker.Add("postgres", db.Ping)
+	checker.Add("kafka_producer", kafka.ProducerCheck(kafkaClient))
+	checker.Add("kafka_consumers", consumers.Check)
+	go checker.Watch(ctx, healthWatchInterval)
+
+	server := transport.NewServer(checker)
+	fmt.Println("server is starting...")
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-serverErr:
 		log.Error().Err(err).Msg("Server hasn't been started.")
+	}
+	stop()
+
+	fmt.Println("server is shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout))
+	defer cancel()
+	shutdown.Service{
+		Server:      server,
+		Consumers:   consumers,
+		Background:  &background,
+		Metrics:     metrics.Metrics,
+		Tracing:     shutdownTracing,
+		Producer:    producer,
+		KafkaClient: kafkaClient,
+		DB:          db,
+	}.Run(shutdownCtx)
+
+	if err != nil {
 		os.Exit(1)
 	}
 }
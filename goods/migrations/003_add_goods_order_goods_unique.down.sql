@@ -0,0 +1 @@
+ALTER TABLE goods DROP CONSTRAINT IF EXISTS goods_order_id_goods_id_key;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM goods a USING goods b
+WHERE a.order_id = b.order_id AND a.goods_id = b.goods_id AND a.id > b.id;
+
+ALTER TABLE goods ADD CONSTRAINT goods_order_id_goods_id_key UNIQUE (order_id, goods_id);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS order_reservations;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE TABLE order_reservations (
+    order_id     BIGINT PRIMARY KEY,
+    cancelled_at TIMESTAMP WITHOUT TIME ZONE,
+    updated_at   TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
\ No newline at end of file
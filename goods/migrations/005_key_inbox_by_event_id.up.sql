@@ -0,0 +1,8 @@
+ALTER TABLE inbox ADD COLUMN event_id TEXT;
+UPDATE inbox SET event_id = topic || '/' || kafka_partition || '/' || kafka_offset;
+ALTER TABLE inbox ALTER COLUMN event_id SET NOT NULL;
+ALTER TABLE inbox DROP CONSTRAINT inbox_pkey;
+ALTER TABLE inbox DROP COLUMN kafka_partition, DROP COLUMN kafka_offset;
+ALTER TABLE inbox ADD PRIMARY KEY (event_id);
+
+CREATE INDEX inbox_processed_at_idx ON inbox (processed_at);
\ No newline at end of file
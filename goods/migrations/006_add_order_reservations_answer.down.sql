@@ -0,0 +1 @@
+ALTER TABLE order_reservations DROP COLUMN IF EXISTS answer, DROP COLUMN IF EXISTS answered_at;
\ No newline at end of file
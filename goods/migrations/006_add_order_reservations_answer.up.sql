@@ -0,0 +1 @@
+ALTER TABLE order_reservations ADD COLUMN answer TEXT, ADD COLUMN answered_at TIMESTAMP WITHOUT TIME ZONE;
\ No newline at end of file
@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+)
+
+func newTestProducer(t *testing.T) *mocks.SyncProducer {
+	t.Helper()
+	t.Setenv("GOODS_CREATED_TOPIC", "goods_created_v1")
+	t.Setenv("GOODS_REJECTED_TOPIC", "goods_rejected_v1")
+	producer := mocks.NewSyncProducer(t, nil)
+	t.Cleanup(func() { _ = producer.Close() })
+
+	return producer
+}
+
+// expectAnswer expects the goods service to send one message to the topic.
+func expectAnswer(producer *mocks.SyncProducer, topic string) {
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != topic {
+			return fmt.Errorf("message has been sent to %s, %s is expected", msg.Topic, topic)
+		}
+		return nil
+	})
+}
+
+// newTestMessage builds the message the way the order service publishes it, with the event ID header.
+func newTestMessage(t *testing.T, topic string, offset int64, schema, eventID string, event interface{}) *sarama.ConsumerMessage {
+	t.Helper()
+	value, err := events.Marshal(schema, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &sarama.ConsumerMessage{
+		Topic:     topic,
+		Offset:    offset,
+		Value:     value,
+		Timestamp: time.Now(),
+		Headers:   []*sarama.RecordHeader{{Key: []byte(kafka.HeaderEventID), Value: []byte(eventID)}},
+	}
+}
+
+func orderCreatedMessage(t *testing.T, offset, orderID int64, goodsIds []int64) *sarama.ConsumerMessage {
+	return newTestMessage(t, events.SchemaOrderCreatedV1, offset, events.SchemaOrderCreatedV1, events.ID(events.OrderCreated, orderID), events.OrderCreatedV1{Data: events.Order{
+		ID:       orderID,
+		GoodsIds: goodsIds,
+	}})
+}
+
+func orderCancelledMessage(t *testing.T, offset, orderID int64, goodsIds []int64) *sarama.ConsumerMessage {
+	return newTestMessage(t, events.SchemaOrderCancelledV1, offset, events.SchemaOrderCancelledV1, events.ID(events.OrderCancelled, orderID), events.OrderCancelledV1{Data: events.Order{
+		ID:       orderID,
+		GoodsIds: goodsIds,
+	}})
+}
@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+)
+
+type OrderCancelledHandler struct {
+	db    *pgxpool.Pool
+	inbox inbox.Inbox
+}
+
+func BuildOrderCancelledHandler(db *pgxpool.Pool, in inbox.Inbox) OrderCancelledHandler {
+	return OrderCancelledHandler{db: db, inbox: in}
+}
+
+// Handle releases the goods reserved for the order, the message is recorded in the inbox in the same transaction.
+// order_cancelled_v1 and order_created are consumed independently, so the cancellation can come first:
+// it's recorded in order_reservations and the order_created processed later doesn't reserve the goods.
+func (och OrderCancelledHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	oce := events.OrderCancelledV1{}
+	err := events.Unmarshal(events.SchemaOrderCancelledV1, msg.Value, &oce)
+	if err != nil {
+		return kafka.DecodeError(err)
+	}
+
+	tx, err := och.db.Begin(ctx)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+	defer tx.Rollback(ctx)
+
+	fresh, err := och.inbox.Mark(ctx, tx, msg)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+	if !fresh {
+		return nil
+	}
+
+	err = cancelReservation(ctx, tx, oce.Data.ID)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM goods WHERE order_id = $1`, oce.Data.ID)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	return nil
+}
+
+// cancelReservation records the cancellation of the order. The row is locked until tx ends, so the goods
+// reserved by a concurrent order_created are committed before the DELETE and released by it.
+func cancelReservation(ctx context.Context, tx pgx.Tx, orderID int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO order_reservations (order_id, cancelled_at, updated_at) VALUES ($1, NOW(), NOW())
+		ON CONFLICT (order_id) DO UPDATE SET cancelled_at = NOW(), updated_at = NOW()`, orderID)
+
+	return err
+}
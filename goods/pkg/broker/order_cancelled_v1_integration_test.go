@@ -0,0 +1,92 @@
+//go:build integration
+// +build integration
+
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kybuk_oo/example_go_metrics/goods/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/testdb"
+)
+
+func TestOrderCancelledBeforeCreatedReservesNothing(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	in := inbox.New(metrics.Metrics)
+	ctx := context.Background()
+
+	err := BuildOrderCancelledHandler(db, in).Handle(ctx, orderCancelledMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No answer is expected by the producer: the order has already left PENDING.
+	err = BuildOrderCreatedHandler(db, producer, metrics, in, events.SchemaOrderCreatedV1).Handle(ctx, orderCreatedMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 0 {
+		t.Fatalf("%d goods are reserved for the cancelled order", count)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM inbox`); count != 2 {
+		t.Fatalf("inbox has %d records, 2 are expected", count)
+	}
+}
+
+func TestOrderCancelledAfterCreatedReleasesGoods(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	in := inbox.New(metrics.Metrics)
+	ctx := context.Background()
+
+	expectAnswer(producer, "goods_created_v1")
+	err := BuildOrderCreatedHandler(db, producer, metrics, in, events.SchemaOrderCreatedV1).Handle(ctx, orderCreatedMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 2 {
+		t.Fatalf("%d goods are reserved, 2 are expected", count)
+	}
+
+	err = BuildOrderCancelledHandler(db, in).Handle(ctx, orderCancelledMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 0 {
+		t.Fatalf("%d goods are still reserved for the cancelled order", count)
+	}
+}
+
+// The expired order has been republished, so goods may process its order_created before and after the cancellation.
+func TestOrderCreatedRepublishedAfterCancelReservesNothing(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	in := inbox.New(metrics.Metrics)
+	created := BuildOrderCreatedHandler(db, producer, metrics, in, events.SchemaOrderCreatedV1)
+	ctx := context.Background()
+
+	expectAnswer(producer, "goods_created_v1")
+	err := created.Handle(ctx, orderCreatedMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BuildOrderCancelledHandler(db, in).Handle(ctx, orderCancelledMessage(t, 1, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = created.Handle(ctx, orderCreatedMessage(t, 2, 1, []int64{10, 11}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 0 {
+		t.Fatalf("%d goods are reserved for the expired order", count)
+	}
+}
@@ -2,110 +2,283 @@ package broker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/kybuk_oo/example_go_metrics/goods/pkg/model"
-	"github.com/rs/zerolog/log"
+	"github.com/kybuk_oo/example_go_metrics/goods/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type OrderCreatedEvent struct {
-	Data struct {
-		ID       int64   `json:"id"`
-		GoodsIds []int64 `json:"goods_ids"`
-	} `json:"data"`
-}
+// errReservationUnavailable means the reservation has failed because of the DB rather than the goods,
+// nothing has been reserved, so the event is retried instead of being rejected.
+var errReservationUnavailable = errors.New("reservation hasn't been made")
 
+// OrderCreatedHandler consumes order_created of the version given by schema,
+// the older versions are upcast to the latest one.
 type OrderCreatedHandler struct {
 	db       *pgxpool.Pool
 	producer sarama.SyncProducer
+	metrics  monitoring.Metrics
+	inbox    inbox.Inbox
+	schema   string
 }
 
-func BuildOrderCreatedHandler(db *pgxpool.Pool, producer sarama.SyncProducer) OrderCreatedHandler {
-	return OrderCreatedHandler{db, producer}
+func BuildOrderCreatedHandler(db *pgxpool.Pool, producer sarama.SyncProducer, metrics monitoring.Metrics, in inbox.Inbox, schema string) OrderCreatedHandler {
+	return OrderCreatedHandler{db, producer, metrics, in, schema}
 }
 
-func (och OrderCreatedHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		oce := OrderCreatedEvent{}
-		err := json.Unmarshal(msg.Value, &oce)
-		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been handled.")
-			session.MarkMessage(msg, "")
-			continue
+// Handle reserves the goods of the order and answers with goods_created_v1 or, when the goods can't be
+// reserved, with goods_rejected_v1. The DB failure is retried and doesn't reject the order.
+// The order cancelled before its order_created has been processed isn't reserved and isn't answered,
+// it has already left PENDING.
+func (och OrderCreatedHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	oce, err := events.DecodeOrderCreated(och.schema, msg.Value, msg.Timestamp)
+	if err != nil {
+		return kafka.DecodeError(err)
+	}
+
+	start := time.Now()
+	result, err := och.reserveOnce(ctx, msg, oce.OrderID, oce.GoodsIds)
+	if err != nil {
+		return err
+	}
+	if result != "" {
+		och.metrics.Histogram["reservation_duration_seconds"].With(prometheus.Labels{"result": result}).Observe(time.Since(start).Seconds())
+	}
+
+	return och.answer(ctx, oce.OrderID)
+}
+
+// reserveOnce reserves the goods and stores the answer in one transaction with the inbox record.
+// It returns the result of the reservation or "" for the duplicate.
+func (och OrderCreatedHandler) reserveOnce(ctx context.Context, msg *sarama.ConsumerMessage, orderID int64, goodsIds []int64) (string, error) {
+	tx, err := och.db.Begin(ctx)
+	if err != nil {
+		return "", kafka.Retryable(kafka.ReasonDBError, err)
+	}
+	defer tx.Rollback(ctx)
+
+	fresh, err := och.inbox.Mark(ctx, tx, msg)
+	if err != nil {
+		return "", kafka.Retryable(kafka.ReasonDBError, err)
+	}
+	if !fresh {
+		return "", nil
+	}
+
+	cancelled, err := lockReservation(ctx, tx, orderID)
+	if err != nil {
+		return "", kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	result := "cancelled"
+	if cancelled {
+		requestid.Logger(ctx).Info().Int64("order_id", orderID).Msg("Order has been cancelled, goods haven't been reserved.")
+	} else {
+		result = "reserved"
+		answer := events.GoodsCreated
+		err = och.reserve(ctx, tx, orderID, goodsIds)
+		switch {
+		case errors.Is(err, errReservationUnavailable):
+			return "", kafka.Retryable(kafka.ReasonDBError, err)
+		case err != nil:
+			requestid.Logger(ctx).Error().Err(err).Int64("order_id", orderID).Msg("Goods haven't been reserved.")
+			result = "rejected"
+			answer = events.GoodsRejected
 		}
 
-		ctx := context.Background()
-		tx, err := och.db.Begin(ctx)
-		for _, goodsID := range oce.Data.GoodsIds {
-			_, err = och.db.Exec(context.Background(), `INSERT INTO goods (goods_id, order_id, created_at) VALUES ($1, $2, NOW())`, goodsID, oce.Data.ID)
-			if err != nil {
-				tx.Rollback(ctx)
-				log.Error().Err(err).Msg("Inserting error.")
-
-				err := och.sendRejected(oce.Data.ID)
-				if err != nil {
-					log.Error().Err(err).Msg("Event hasn't been sent.")
-				}
-				session.MarkMessage(msg, "")
-				continue
-			}
+		_, err = tx.Exec(ctx, `UPDATE order_reservations SET answer = $2 WHERE order_id = $1`, orderID, answer)
+		if err != nil {
+			return "", kafka.Retryable(kafka.ReasonDBError, err)
 		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return "", kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	return result, nil
+}
+
+// answer sends the stored answer of the order unless it has been sent or the order has been cancelled.
+// The failed send is retried: the inbox skips the redelivered copy, which sends the answer again.
+func (och OrderCreatedHandler) answer(ctx context.Context, orderID int64) error {
+	var answer string
+	err := och.db.QueryRow(ctx, `
+		SELECT answer FROM order_reservations
+		WHERE order_id = $1 AND answer IS NOT NULL AND answered_at IS NULL AND cancelled_at IS NULL`, orderID).Scan(&answer)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	if answer == events.GoodsCreated {
+		err = och.sendCreated(ctx, orderID)
+	} else {
+		err = och.sendRejected(ctx, orderID)
+	}
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonProduceError, err)
+	}
+
+	_, err = och.db.Exec(ctx, `UPDATE order_reservations SET answered_at = NOW() WHERE order_id = $1`, orderID)
+	if err != nil {
+		return kafka.Retryable(kafka.ReasonDBError, err)
+	}
+
+	return nil
+}
 
-		err = tx.Commit(ctx)
+// lockReservation locks the order in order_reservations until tx ends and tells whether it has been cancelled.
+// The concurrent order_cancelled_v1 waits for the reservation to be committed and releases it then.
+func lockReservation(ctx context.Context, tx pgx.Tx, orderID int64) (cancelled bool, err error) {
+	err = tx.QueryRow(ctx, `
+		INSERT INTO order_reservations (order_id, updated_at) VALUES ($1, NOW())
+		ON CONFLICT (order_id) DO UPDATE SET updated_at = NOW()
+		RETURNING cancelled_at IS NOT NULL`, orderID).Scan(&cancelled)
+
+	return cancelled, err
+}
+
+// ReservationRetentionPolicy deletes the order_reservations rows with the retention of the inbox:
+// the cancellation is remembered as long as a copy of order_created could still be recognized.
+func ReservationRetentionPolicy() retention.Policy {
+	return retention.Policy{Table: "order_reservations", Column: "updated_at", TTL: inbox.RetentionPolicy().TTL}
+}
+
+// reserve inserts the goods rows of the order in a savepoint of tx, either all of them or none: the failed
+// reservation is rolled back while the inbox record is kept. The goods already reserved for the order,
+// e.g. by a republished event, are skipped. The goods which can't be reserved reject the order,
+// the DB failure returns errReservationUnavailable.
+func (och OrderCreatedHandler) reserve(ctx context.Context, tx pgx.Tx, orderID int64, goodsIds []int64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "reserveGoods", trace.WithAttributes(attribute.Int64("order.id", orderID)))
+	defer func() { tracing.End(span, err) }()
+
+	defer func() {
+		if errors.Is(err, errReservationUnavailable) {
+			return
+		}
+		result := "reserved"
 		if err != nil {
-			err := tx.Rollback(ctx)
-			log.Error().Err(err).Msg("Transaction commit error.")
-			rErr := och.sendRejected(oce.Data.ID)
-			if rErr != nil {
-				log.Error().Err(rErr).Msg("Event hasn't been sent.")
-			}
-			session.MarkMessage(msg, "")
-			continue
+			result = "rejected"
+		}
+		och.metrics.Counter["reservation_goods"].With(prometheus.Labels{"result": result}).Add(float64(len(goodsIds)))
+	}()
+
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errReservationUnavailable, err)
+	}
+	defer savepoint.Rollback(ctx)
+
+	err = insertGoods(ctx, savepoint, orderID, goodsIds)
+	if err == nil {
+		err = savepoint.Commit(ctx)
+	}
+	if err != nil && !isDataError(err) {
+		return fmt.Errorf("%w: %v", errReservationUnavailable, err)
+	}
+
+	return err
+}
+
+// insertGoods sends the inserts of all the goods in one batch. The first failed insert
+// aborts the rest of them.
+func insertGoods(ctx context.Context, tx pgx.Tx, orderID int64, goodsIds []int64) (err error) {
+	if len(goodsIds) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, goodsID := range goodsIds {
+		batch.Queue(`
+			INSERT INTO goods (goods_id, order_id, created_at) VALUES ($1, $2, NOW())
+			ON CONFLICT (order_id, goods_id) DO NOTHING`, goodsID, orderID)
+	}
+	results := tx.SendBatch(ctx, batch)
+	defer func() {
+		closeErr := results.Close()
+		if err == nil {
+			err = closeErr
 		}
+	}()
 
-		err = och.sendCreated(oce.Data.ID)
+	for _, goodsID := range goodsIds {
+		_, err = results.Exec()
 		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been sent.")
+			return fmt.Errorf("goods %d: %w", goodsID, err)
 		}
-		session.MarkMessage(msg, "")
 	}
 
 	return nil
 }
 
-func (och OrderCreatedHandler) sendRejected(orderID int64) error {
-	msg := model.RejectedGoodsMsg{Data: model.Goods{
-		OrderID: orderID,
-	}}
-	msgStr, err := json.Marshal(msg)
-	if err != nil {
-		return err
+// isDataError tells the goods which can't be reserved (data exception or integrity constraint violation)
+// from the DB failure.
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
 	}
-	producerMsg := &sarama.ProducerMessage{Topic: os.Getenv("GOODS_REJECTED_TOPIC"), Value: sarama.StringEncoder(msgStr)}
-	_, _, err = och.producer.SendMessage(producerMsg)
-	return err
+
+	return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
 }
 
-func (och OrderCreatedHandler) sendCreated(orderID int64) error {
-	msg := model.CreatedGoodsMsg{Data: model.Goods{
+func (och OrderCreatedHandler) sendRejected(ctx context.Context, orderID int64) error {
+	return och.send(ctx, os.Getenv("GOODS_REJECTED_TOPIC"), events.SchemaGoodsRejectedV1, events.GoodsRejected, orderID, events.GoodsRejectedV1{Data: events.Goods{
 		OrderID: orderID,
-	}}
-	msgStr, err := json.Marshal(msg)
+	}})
+}
+
+func (och OrderCreatedHandler) sendCreated(ctx context.Context, orderID int64) error {
+	return och.send(ctx, os.Getenv("GOODS_CREATED_TOPIC"), events.SchemaGoodsCreatedV1, events.GoodsCreated, orderID, events.GoodsCreatedV1{Data: events.Goods{
+		OrderID: orderID,
+	}})
+}
+
+// send publishes the event with the request ID and the trace context of ctx. The event is keyed
+// by the order ID like the events of the order service, so the answers of an order keep their order.
+// The re-sent answer has the same event ID, so the order service skips it.
+func (och OrderCreatedHandler) send(ctx context.Context, topic, schema, name string, orderID int64, event interface{}) (err error) {
+	msgStr, err := events.Marshal(schema, event)
 	if err != nil {
 		return err
 	}
-	producerMsg := &sarama.ProducerMessage{Topic: os.Getenv("GOODS_CREATED_TOPIC"), Value: sarama.StringEncoder(msgStr)}
-	_, _, err = och.producer.SendMessage(producerMsg)
-	return err
-}
+	producerMsg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(strconv.FormatInt(orderID, 10)),
+		Value:   sarama.StringEncoder(msgStr),
+		Headers: []sarama.RecordHeader{requestid.KafkaRecordHeader(ctx), kafka.EventIDRecordHeader(events.ID(name, orderID))},
+	}
+	_, span := tracing.StartProducerSpan(ctx, producerMsg)
+	defer func() { tracing.End(span, err) }()
 
-func (OrderCreatedHandler) Setup(sarama.ConsumerGroupSession) error {
-	return nil
-}
+	start := time.Now()
+	_, _, err = och.producer.SendMessage(producerMsg)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	och.metrics.Histogram["producer_send_duration_seconds"].With(prometheus.Labels{"topic": topic, "status": status}).Observe(time.Since(start).Seconds())
 
-func (OrderCreatedHandler) Cleanup(sarama.ConsumerGroupSession) error {
-	return nil
+	return err
 }
@@ -0,0 +1,135 @@
+//go:build integration
+// +build integration
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/kybuk_oo/example_go_metrics/goods/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/testdb"
+)
+
+// The republished order_created or the copy re-sent after a failed commit of the outbox
+// gets a new offset but keeps the event ID, so it's skipped and answered only once.
+func TestOrderCreatedCopyIsSkipped(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	handler := BuildOrderCreatedHandler(db, producer, metrics, inbox.New(metrics.Metrics), events.SchemaOrderCreatedV1)
+	ctx := context.Background()
+
+	expectAnswer(producer, "goods_created_v1")
+	for offset := int64(1); offset <= 2; offset++ {
+		err := handler.Handle(ctx, orderCreatedMessage(t, offset, 1, []int64{10, 11}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM inbox`); count != 1 {
+		t.Fatalf("inbox has %d records, 1 is expected", count)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 2 {
+		t.Fatalf("%d goods are reserved, 2 are expected", count)
+	}
+}
+
+// failGoods makes the insert of goods 13 fail with a data error and of goods 99 with a DB failure.
+const failGoods = `
+	CREATE FUNCTION fail_goods() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.goods_id = 13 THEN
+			RAISE EXCEPTION 'goods 13 is unavailable' USING ERRCODE = 'check_violation';
+		END IF;
+		IF NEW.goods_id = 99 THEN
+			RAISE EXCEPTION 'goods 99 is being reserved concurrently' USING ERRCODE = 'serialization_failure';
+		END IF;
+		RETURN NEW;
+	END $$ LANGUAGE plpgsql;
+
+	CREATE TRIGGER fail_goods BEFORE INSERT ON goods FOR EACH ROW EXECUTE FUNCTION fail_goods();`
+
+func TestReservationFailingItemLeavesNoRows(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	_, err := db.Exec(context.Background(), failGoods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	handler := BuildOrderCreatedHandler(db, producer, metrics, inbox.New(metrics.Metrics), events.SchemaOrderCreatedV1)
+
+	expectAnswer(producer, "goods_rejected_v1")
+	err = handler.Handle(context.Background(), orderCreatedMessage(t, 1, 1, []int64{12, 13, 14}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods`); count != 0 {
+		t.Fatalf("%d goods are left after the failed reservation", count)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM inbox`); count != 1 {
+		t.Fatalf("inbox has %d records, the rejected order has to be recorded", count)
+	}
+}
+
+func TestReservationDBFailureIsRetried(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	_, err := db.Exec(context.Background(), failGoods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	handler := BuildOrderCreatedHandler(db, producer, metrics, inbox.New(metrics.Metrics), events.SchemaOrderCreatedV1)
+
+	// No answer is expected: the order is neither reserved nor rejected.
+	err = handler.Handle(context.Background(), orderCreatedMessage(t, 1, 1, []int64{12, 99, 14}))
+
+	var handlerErr kafka.HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Permanent || handlerErr.Reason != kafka.ReasonDBError {
+		t.Fatalf("error = %v, retryable %s is expected", err, kafka.ReasonDBError)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods`); count != 0 {
+		t.Fatalf("%d goods are left after the failed reservation", count)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM inbox`); count != 0 {
+		t.Fatalf("inbox has %d records, the retried order mustn't be recorded", count)
+	}
+}
+
+// The reservation is committed before the answer is sent, the failed send is retried by the redelivered copy.
+func TestOrderCreatedAnswerIsResent(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := newTestProducer(t)
+	metrics := monitoring.NewMetrics()
+	handler := BuildOrderCreatedHandler(db, producer, metrics, inbox.New(metrics.Metrics), events.SchemaOrderCreatedV1)
+	ctx := context.Background()
+
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	err := handler.Handle(ctx, orderCreatedMessage(t, 1, 1, []int64{10}))
+
+	var handlerErr kafka.HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Permanent || handlerErr.Reason != kafka.ReasonProduceError {
+		t.Fatalf("error = %v, retryable %s is expected", err, kafka.ReasonProduceError)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM goods WHERE order_id = $1`, 1); count != 1 {
+		t.Fatalf("%d goods are reserved, the reservation has to be committed", count)
+	}
+
+	expectAnswer(producer, "goods_created_v1")
+	err = handler.Handle(ctx, orderCreatedMessage(t, 1, 1, []int64{10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := testdb.Count(t, db, `SELECT COUNT(*) FROM order_reservations WHERE answered_at IS NOT NULL`); count != 1 {
+		t.Fatalf("%d answers are recorded as sent, 1 is expected", count)
+	}
+}
@@ -0,0 +1,76 @@
+package monitoring
+
+import (
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics keeps the service metrics together with the shared health and Kafka ones.
+type Metrics struct {
+	prom.Metrics
+}
+
+// StartMetrics creates the metrics and serves them on /metrics.
+func StartMetrics() (Metrics, error) {
+	counters := NewMetrics()
+
+	var err error
+	counters.Metrics, err = prom.RunPrometheus(counters.Metrics)
+	if err != nil {
+		return counters, err
+	}
+
+	return counters, nil
+}
+
+// NewMetrics creates the metrics without registering them, e.g. for the tests.
+func NewMetrics() Metrics {
+	counters := Metrics{Metrics: prom.NewMetrics()}
+	/*
+		# HELP reservation_goods Количество товаров в заказах по результату резервирования
+		# TYPE reservation_goods counter
+		reservation_goods{result="reserved"} 12
+		reservation_goods{result="rejected"} 2
+	*/
+	reservationGoods := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_goods",
+		Name:      "reservation_goods",
+		Help:      "Количество товаров в заказах по результату резервирования",
+	}, []string{"result"})
+	counters.Counter["reservation_goods"] = reservationGoods
+
+	/*
+		# HELP reservation_duration_seconds Продолжительность транзакции резервирования товаров заказа, включая запись в inbox
+		# TYPE reservation_duration_seconds histogram
+		reservation_duration_seconds_bucket{result="reserved", le="0.01"} 3
+		reservation_duration_seconds_count{result="cancelled"} 1
+	*/
+	reservationDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "example_go_metrics_goods",
+			Name:      "reservation_duration_seconds",
+			Help:      "Продолжительность транзакции резервирования товаров заказа, включая запись в inbox",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}, []string{"result"})
+	counters.Histogram["reservation_duration_seconds"] = reservationDurationSeconds
+
+	producerSendDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "example_go_metrics_goods",
+			Name:      "producer_send_duration_seconds",
+			Help:      "Продолжительность отправки сообщения в Kafka",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}, []string{"topic", "status"})
+	counters.Histogram["producer_send_duration_seconds"] = producerSendDurationSeconds
+
+	health.AddMetrics(counters.Metrics, "example_go_metrics_goods")
+	inbox.AddMetrics(counters.Metrics, "example_go_metrics_goods")
+	retention.AddMetrics(counters.Metrics, "example_go_metrics_goods")
+	kafka.AddMetrics(counters.Metrics, "example_go_metrics_goods")
+
+	return counters
+}
@@ -1,23 +1,37 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 	"os"
 
 	"github.com/gorilla/mux"
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
 )
 
 type Server struct {
-	router *mux.Router
+	router     *mux.Router
+	httpServer *http.Server
 }
 
-func NewServer() Server {
+func NewServer(checker health.Checker) Server {
 	s := Server{}
 	s.router = mux.NewRouter()
 
+	s.router.HandleFunc("/healthz", health.Healthz).Methods(http.MethodGet)
+	s.router.HandleFunc("/readyz", checker.Readyz).Methods(http.MethodGet)
+
+	s.httpServer = &http.Server{Addr: ":" + os.Getenv("HTTP_BIND"), Handler: s.router}
+
 	return s
 }
 
+// Start serves HTTP until Shutdown is called, then it returns http.ErrServerClosed.
 func (s Server) Start() error {
-	return http.ListenAndServe(":"+os.Getenv("HTTP_BIND"), s.router)
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops accepting connections and waits for the in-flight requests until ctx is done.
+func (s Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
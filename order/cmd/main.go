@@ -4,24 +4,49 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/Shopify/sarama"
 	"github.com/kybuk_oo/example_go_metrics/orders/pkg/broker"
-	"github.com/kybuk_oo/example_go_metrics/orders/pkg/datastore"
 	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/outbox"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
 	"github.com/kybuk_oo/example_go_metrics/orders/transport"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/datastore"
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/kybuk_oo/example_go_metrics/platform/shutdown"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultRetentionInterval = 10 * time.Minute
+	healthWatchInterval      = 15 * time.Second
+)
+
 func main() {
-	db := datastore.InitDB()
-	producer := broker.InitKafkaProducer()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	handlers := map[string]sarama.ConsumerGroupHandler{
-		os.Getenv("GOODS_CREATED_TOPIC"):  broker.BuildGoodsCreatedHandler(db),
-		os.Getenv("GOODS_REJECTED_TOPIC"): broker.BuildGoodsRejectedHandler(db),
+	shutdownTracing, err := tracing.Init(ctx, "order")
+	if err != nil {
+		log.Error().Err(err).Msg("Tracing hasn't been started.")
 	}
-	broker.RunConsumers(context.Background(), handlers)
+
+	db := datastore.InitDB()
+	kafkaCfg, err := kafka.LoadClientConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Kafka client hasn't been configured.")
+		os.Exit(1)
+	}
+	producer, kafkaClient := kafka.InitProducer(kafkaCfg)
 
 	metrics, err := monitoring.StartMetrics()
 	if err != nil {
@@ -29,13 +54,77 @@ func main() {
 		os.Exit(1)
 	}
 
+	processed := inbox.New(metrics.Metrics)
+	handlers := map[string]kafka.MessageHandler{
+		os.Getenv("GOODS_CREATED_TOPIC"):  broker.BuildGoodsCreatedHandler(db, processed, metrics),
+		os.Getenv("GOODS_REJECTED_TOPIC"): broker.BuildGoodsRejectedHandler(db, processed, metrics),
+	}
+	retryHandlers, err := kafka.BuildRetryHandlers(handlers, kafka.NewRetryPolicy(), producer, metrics.Metrics)
+	if err != nil {
+		log.Error().Err(err).Msg("Consumers haven't been configured.")
+		os.Exit(1)
+	}
+	consumers, err := kafka.RunConsumers(ctx, kafkaCfg, retryHandlers)
+	if err != nil {
+		log.Error().Err(err).Msg("Consumers haven't been started.")
+		os.Exit(1)
+	}
+
 	fmt.Println("server metrics is starting...")
 
 	fmt.Println("server is starting...")
-	server := transport.NewServer(db, producer, metrics)
-	err = server.Start()
-	if err != nil {
+	checker := health.NewChecker(metrics.GaugeVec["dependency_up"])
+	checker.Add("postgres", db.Ping)
+	checker.Add("kafka_producer", kafka.ProducerCheck(kafkaClient))
+	checker.Add("kafka_consumers", consumers.Check)
+	go checker.Watch(ctx, healthWatchInterval)
+
+	var background sync.WaitGroup
+	relay := outbox.NewRelay(db, producer, metrics)
+	sweeper := saga.NewSweeper(db, metrics)
+	cleaner := retention.NewCleaner(db, metrics.Metrics, outbox.RetentionPolicy(), inbox.RetentionPolicy())
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		relay.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		sweeper.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		cleaner.Run(ctx, config.Duration("RETENTION_INTERVAL", defaultRetentionInterval))
+	}()
+
+	server := transport.NewServer(db, metrics, checker)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-serverErr:
 		log.Error().Err(err).Msg("Server hasn't been started.")
+	}
+	stop()
+
+	fmt.Println("server is shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout))
+	defer cancel()
+	shutdown.Service{
+		Server:      server,
+		Consumers:   consumers,
+		Background:  &background,
+		Metrics:     metrics.Metrics,
+		Tracing:     shutdownTracing,
+		Producer:    producer,
+		KafkaClient: kafkaClient,
+		DB:          db,
+	}.Run(shutdownCtx)
+
+	if err != nil {
 		os.Exit(1)
 	}
 }
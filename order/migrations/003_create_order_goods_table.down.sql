@@ -0,0 +1 @@
+DROP TABLE IF EXISTS order_goods;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE order_goods (
+    id       BIGSERIAL PRIMARY KEY,
+    order_id BIGINT NOT NULL,
+    goods_id BIGINT NOT NULL,
+
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX order_goods_order_id_idx ON order_goods (order_id);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT NOW();
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_created_at_id_idx;
+DROP INDEX IF EXISTS orders_status_id_id_idx;
+DROP INDEX IF EXISTS orders_user_id_id_idx;
\ No newline at end of file
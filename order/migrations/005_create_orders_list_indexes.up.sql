@@ -0,0 +1,3 @@
+CREATE INDEX orders_user_id_id_idx ON orders (user_id, id);
+CREATE INDEX orders_status_id_id_idx ON orders (status_id, id);
+CREATE INDEX orders_created_at_id_idx ON orders (created_at, id);
\ No newline at end of file
@@ -0,0 +1 @@
+DELETE FROM statuses WHERE id = 3;
\ No newline at end of file
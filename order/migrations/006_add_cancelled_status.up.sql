@@ -0,0 +1 @@
+INSERT INTO statuses (id, name) VALUES (3, 'CANCELLED');
\ No newline at end of file
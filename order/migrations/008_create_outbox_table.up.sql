@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+    id         BIGSERIAL PRIMARY KEY,
+    topic      TEXT NOT NULL,
+    key        TEXT NOT NULL DEFAULT '',
+    payload    TEXT NOT NULL,
+    headers    JSONB NOT NULL DEFAULT '{}',
+    attempts   INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    sent_at    TIMESTAMP WITHOUT TIME ZONE
+);
+
+CREATE INDEX outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL;
\ No newline at end of file
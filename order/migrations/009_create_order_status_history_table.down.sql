@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS order_status_history;
+
+-- Before the saga a rejected order was deleted and there was no timeout, both end up cancelled.
+UPDATE orders SET status_id = 3, updated_at = NOW() WHERE status_id IN (4, 5);
+DELETE FROM statuses WHERE id IN (4, 5);
+UPDATE statuses SET name = 'CREATED' WHERE id = 2;
\ No newline at end of file
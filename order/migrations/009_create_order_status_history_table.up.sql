@@ -0,0 +1,18 @@
+UPDATE statuses SET name = 'GOODS_RESERVED' WHERE id = 2;
+INSERT INTO statuses (id, name) VALUES (4, 'REJECTED'), (5, 'EXPIRED');
+
+CREATE TABLE order_status_history (
+    id             BIGSERIAL PRIMARY KEY,
+    order_id       BIGINT NOT NULL,
+    from_status_id BIGINT,
+    to_status_id   BIGINT NOT NULL,
+    event          TEXT NOT NULL,
+    event_at       TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    created_at     TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+
+    FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    FOREIGN KEY (from_status_id) REFERENCES statuses (id),
+    FOREIGN KEY (to_status_id) REFERENCES statuses (id)
+);
+
+CREATE INDEX order_status_history_order_id_idx ON order_status_history (order_id, id);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS republished_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS republish_count;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN republish_count INT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN republished_at TIMESTAMP WITHOUT TIME ZONE;
\ No newline at end of file
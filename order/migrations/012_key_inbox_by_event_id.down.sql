@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS inbox;
+
+CREATE TABLE inbox (
+    topic           TEXT NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_offset    BIGINT NOT NULL,
+    processed_at    TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+
+    PRIMARY KEY (topic, kafka_partition, kafka_offset)
+);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS outbox_unsent_idx;
+CREATE INDEX outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL;
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS failed_at;
+ALTER TABLE outbox DROP COLUMN IF EXISTS retry_at;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE outbox ADD COLUMN retry_at TIMESTAMP WITHOUT TIME ZONE;
+ALTER TABLE outbox ADD COLUMN failed_at TIMESTAMP WITHOUT TIME ZONE;
+
+DROP INDEX outbox_unsent_idx;
+CREATE INDEX outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL AND failed_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,56 @@
+//go:build integration
+// +build integration
+
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+)
+
+// insertOrder creates the order in the state.
+func insertOrder(t *testing.T, db *pgxpool.Pool, state saga.State) int64 {
+	t.Helper()
+	var orderID int64
+	err := db.QueryRow(context.Background(), `INSERT INTO orders (user_id, status_id, created_at) VALUES (1, $1, NOW()) RETURNING id`,
+		int64(state)).Scan(&orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return orderID
+}
+
+func orderState(t *testing.T, db *pgxpool.Pool, orderID int64) saga.State {
+	t.Helper()
+	var statusID int64
+	err := db.QueryRow(context.Background(), `SELECT status_id FROM orders WHERE id = $1`, orderID).Scan(&statusID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return saga.State(statusID)
+}
+
+// goodsAnswerMessage builds the answer the way the goods service sends it. The empty eventID leaves
+// the header out, so the message is identified by its offset.
+func goodsAnswerMessage(t *testing.T, schema string, offset int64, eventID string, orderID int64) *sarama.ConsumerMessage {
+	t.Helper()
+	value, err := events.Marshal(schema, events.GoodsCreatedV1{Data: events.Goods{OrderID: orderID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &sarama.ConsumerMessage{Topic: schema, Offset: offset, Value: value, Timestamp: time.Now()}
+	if eventID != "" {
+		msg.Headers = []*sarama.RecordHeader{{Key: []byte(kafka.HeaderEventID), Value: []byte(eventID)}}
+	}
+
+	return msg
+}
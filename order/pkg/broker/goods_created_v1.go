@@ -2,52 +2,38 @@ package broker
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 
 	"github.com/Shopify/sarama"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/rs/zerolog/log"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
 )
 
-type GoodsCreatedEvent struct {
-	Data struct {
-		OrderID int64 `json:"order_id"`
-	} `json:"data"`
-}
-
 type GoodsCreatedHandler struct {
-	db *pgxpool.Pool
+	db      *pgxpool.Pool
+	inbox   inbox.Inbox
+	metrics monitoring.Metrics
 }
 
-func BuildGoodsCreatedHandler(db *pgxpool.Pool) GoodsCreatedHandler {
-	return GoodsCreatedHandler{db: db}
+func BuildGoodsCreatedHandler(db *pgxpool.Pool, in inbox.Inbox, metrics monitoring.Metrics) GoodsCreatedHandler {
+	return GoodsCreatedHandler{db: db, inbox: in, metrics: metrics}
 }
 
-func (gch GoodsCreatedHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		gce := GoodsCreatedEvent{}
-		err := json.Unmarshal(msg.Value, &gce)
-		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been handled.")
-			session.MarkMessage(msg, "")
-			continue
-		}
-
-		_, err = gch.db.Exec(context.Background(), `UPDATE orders SET status_id = 2 WHERE id = $1`, gce.Data.OrderID)
-		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been inserted.")
-		}
-
-		session.MarkMessage(msg, "")
+func (gch GoodsCreatedHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	gce := events.GoodsCreatedV1{}
+	err := events.Unmarshal(events.SchemaGoodsCreatedV1, msg.Value, &gce)
+	if err != nil {
+		return kafka.DecodeError(err)
 	}
 
-	return nil
-}
-
-func (GoodsCreatedHandler) Setup(sarama.ConsumerGroupSession) error {
-	return nil
-}
+	err = transitOrder(ctx, gch.db, gch.inbox, gch.metrics, msg, gce.Data.OrderID, saga.GoodsReserved, saga.Event{Name: saga.EventGoodsReserved, OccurredAt: msg.Timestamp})
+	if err != nil {
+		return transitionError(fmt.Errorf("order %d: %w", gce.Data.OrderID, err))
+	}
 
-func (GoodsCreatedHandler) Cleanup(sarama.ConsumerGroupSession) error {
 	return nil
 }
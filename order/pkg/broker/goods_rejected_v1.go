@@ -2,52 +2,38 @@ package broker
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 
 	"github.com/Shopify/sarama"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/rs/zerolog/log"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
 )
 
-type GoodsRejectedEvent struct {
-	Data struct {
-		OrderID int64 `json:"order_id"`
-	} `json:"data"`
-}
-
 type GoodsRejectedHandler struct {
-	db *pgxpool.Pool
+	db      *pgxpool.Pool
+	inbox   inbox.Inbox
+	metrics monitoring.Metrics
 }
 
-func BuildGoodsRejectedHandler(db *pgxpool.Pool) GoodsRejectedHandler {
-	return GoodsRejectedHandler{db: db}
+func BuildGoodsRejectedHandler(db *pgxpool.Pool, in inbox.Inbox, metrics monitoring.Metrics) GoodsRejectedHandler {
+	return GoodsRejectedHandler{db: db, inbox: in, metrics: metrics}
 }
 
-func (grh GoodsRejectedHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		gre := GoodsRejectedEvent{}
-		err := json.Unmarshal(msg.Value, &gre)
-		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been handled.")
-			session.MarkMessage(msg, "")
-			continue
-		}
-
-		_, err = grh.db.Exec(context.Background(), `DELETE FROM orders WHERE id = $1`, gre.Data.OrderID)
-		if err != nil {
-			log.Error().Err(err).Msg("Event hasn't been inserted.")
-		}
-
-		session.MarkMessage(msg, "")
+func (grh GoodsRejectedHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	gre := events.GoodsRejectedV1{}
+	err := events.Unmarshal(events.SchemaGoodsRejectedV1, msg.Value, &gre)
+	if err != nil {
+		return kafka.DecodeError(err)
 	}
 
-	return nil
-}
-
-func (GoodsRejectedHandler) Setup(sarama.ConsumerGroupSession) error {
-	return nil
-}
+	err = transitOrder(ctx, grh.db, grh.inbox, grh.metrics, msg, gre.Data.OrderID, saga.Rejected, saga.Event{Name: saga.EventGoodsRejected, OccurredAt: msg.Timestamp})
+	if err != nil {
+		return transitionError(fmt.Errorf("order %d: %w", gre.Data.OrderID, err))
+	}
 
-func (GoodsRejectedHandler) Cleanup(sarama.ConsumerGroupSession) error {
 	return nil
 }
@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReasonUnknownOrder is the reason of dead-lettering the answer for the order which doesn't exist.
+const ReasonUnknownOrder = "unknown_order"
+
+// transitOrder moves the order to the next saga state in its own transaction, the message is recorded
+// in the inbox in the same transaction. The message that has already been processed is skipped.
+// The goods answers move the order only from PENDING, so the answer that arrives after the order has left it
+// is late, e.g. goods_rejected of the expired order or the second answer to a republished order_created:
+// it's counted in saga_late_events and skipped, the inbox record is kept.
+func transitOrder(ctx context.Context, db *pgxpool.Pool, in inbox.Inbox, metrics monitoring.Metrics, msg *sarama.ConsumerMessage, orderID int64, to saga.State, event saga.Event) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "transitOrder", trace.WithAttributes(
+		attribute.Int64("order.id", orderID),
+		attribute.String("order.status", to.String()),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	fresh, err := in.Mark(ctx, tx, msg)
+	if err != nil || !fresh {
+		return err
+	}
+
+	err = saga.Transition(ctx, tx, orderID, to, event)
+	var illegalTransition saga.IllegalTransitionError
+	if errors.As(err, &illegalTransition) {
+		metrics.Counter["saga_late_events"].WithLabelValues(event.Name, illegalTransition.From.String()).Inc()
+		requestid.Logger(ctx).Warn().Int64("order_id", orderID).Str("status", illegalTransition.From.String()).
+			Str("event", event.Name).Msg("Late event has been skipped.")
+		return tx.Commit(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// transitionError classifies the error of transitOrder: the unknown order won't succeed on retry,
+// any other error is a DB error.
+func transitionError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return kafka.Permanent(ReasonUnknownOrder, err)
+	}
+
+	return kafka.Retryable(kafka.ReasonDBError, err)
+}
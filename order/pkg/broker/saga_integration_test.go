@@ -0,0 +1,105 @@
+//go:build integration
+// +build integration
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/testdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The answer re-sent by goods after a failed commit has the same event ID and a new offset.
+func TestGoodsAnswerCopyIsSkipped(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	metrics := monitoring.NewMetrics()
+	handler := BuildGoodsCreatedHandler(db, inbox.New(metrics.Metrics), metrics)
+	orderID := insertOrder(t, db, saga.Pending)
+	eventID := events.ID(events.GoodsCreated, orderID)
+
+	for offset := int64(1); offset <= 2; offset++ {
+		err := handler.Handle(context.Background(), goodsAnswerMessage(t, events.SchemaGoodsCreatedV1, offset, eventID, orderID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if state := orderState(t, db, orderID); state != saga.GoodsReserved {
+		t.Fatalf("order is %s, GOODS_RESERVED is expected", state)
+	}
+	if duplicated := testutil.ToFloat64(metrics.Counter["messages_duplicated"].WithLabelValues(events.SchemaGoodsCreatedV1)); duplicated != 1 {
+		t.Fatalf("messages_duplicated = %v, 1 is expected", duplicated)
+	}
+	if late := testutil.CollectAndCount(metrics.Counter["saga_late_events"]); late != 0 {
+		t.Fatalf("%d late events are counted, the copy isn't late", late)
+	}
+}
+
+func TestLateGoodsAnswerIsSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		event  string
+		state  saga.State
+	}{
+		{name: "goods_created after GOODS_RESERVED", schema: events.SchemaGoodsCreatedV1, event: saga.EventGoodsReserved, state: saga.GoodsReserved},
+		{name: "goods_rejected after GOODS_RESERVED", schema: events.SchemaGoodsRejectedV1, event: saga.EventGoodsRejected, state: saga.GoodsReserved},
+		{name: "goods_created after CANCELLED", schema: events.SchemaGoodsCreatedV1, event: saga.EventGoodsReserved, state: saga.Cancelled},
+		{name: "goods_rejected after EXPIRED", schema: events.SchemaGoodsRejectedV1, event: saga.EventGoodsRejected, state: saga.Expired},
+		{name: "goods_created after REJECTED", schema: events.SchemaGoodsCreatedV1, event: saga.EventGoodsReserved, state: saga.Rejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.New(t, "../../migrations")
+			metrics := monitoring.NewMetrics()
+			in := inbox.New(metrics.Metrics)
+			handlers := map[string]kafka.MessageHandler{
+				events.SchemaGoodsCreatedV1:  BuildGoodsCreatedHandler(db, in, metrics),
+				events.SchemaGoodsRejectedV1: BuildGoodsRejectedHandler(db, in, metrics),
+			}
+			orderID := insertOrder(t, db, tt.state)
+
+			err := handlers[tt.schema].Handle(context.Background(), goodsAnswerMessage(t, tt.schema, 1, "", orderID))
+			if err != nil {
+				t.Fatalf("late answer isn't skipped: %v", err)
+			}
+
+			if state := orderState(t, db, orderID); state != tt.state {
+				t.Fatalf("order is %s, %s is expected", state, tt.state)
+			}
+			if late := testutil.ToFloat64(metrics.Counter["saga_late_events"].WithLabelValues(tt.event, tt.state.String())); late != 1 {
+				t.Fatalf("saga_late_events = %v, 1 is expected", late)
+			}
+			var inboxRecords int
+			err = db.QueryRow(context.Background(), `SELECT COUNT(*) FROM inbox`).Scan(&inboxRecords)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inboxRecords != 1 {
+				t.Fatalf("inbox has %d records, the late answer has to be recorded", inboxRecords)
+			}
+		})
+	}
+}
+
+func TestGoodsAnswerForUnknownOrderIsDeadLettered(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	metrics := monitoring.NewMetrics()
+	handler := BuildGoodsRejectedHandler(db, inbox.New(metrics.Metrics), metrics)
+
+	err := handler.Handle(context.Background(), goodsAnswerMessage(t, events.SchemaGoodsRejectedV1, 1, "", 404))
+
+	var handlerErr kafka.HandlerError
+	if !errors.As(err, &handlerErr) || !handlerErr.Permanent || handlerErr.Reason != ReasonUnknownOrder {
+		t.Fatalf("error = %v, permanent %s is expected", err, ReasonUnknownOrder)
+	}
+}
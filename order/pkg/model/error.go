@@ -0,0 +1,28 @@
+package model
+
+// Codes of the API errors.
+const (
+	ErrCodeInvalidBody  = "invalid_body"
+	ErrCodeBodyTooLarge = "body_too_large"
+	ErrCodeInvalidJSON  = "invalid_json"
+	ErrCodeUnknownField = "unknown_field"
+	ErrCodeRequired     = "required"
+	ErrCodeInvalidValue = "invalid_value"
+	ErrCodeTooMany      = "too_many"
+	ErrCodeDuplicate    = "duplicate"
+	ErrCodeNotFound     = "not_found"
+	ErrCodeConflict     = "conflict"
+	ErrCodeInternal     = "internal_error"
+)
+
+// Error is the JSON body of every error response of the API.
+// Field is set when the error is caused by a particular request field.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
@@ -1,10 +1,23 @@
 package model
 
-type Order struct {
+import "time"
+
+type CreatedOrder struct {
 	ID       int64   `json:"id"`
+	Status   string  `json:"status"`
 	GoodsIds []int64 `json:"goods_ids"`
 }
 
-type CreatedOrderMsg struct {
-	Data Order `json:"data"`
+type OrderInfo struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Status    string    `json:"status"`
+	GoodsIds  []int64   `json:"goods_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OrderList struct {
+	Orders     []OrderInfo `json:"orders"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 }
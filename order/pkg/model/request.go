@@ -1,6 +1,56 @@
 package model
 
+import (
+	"fmt"
+	"time"
+)
+
+// MaxGoodsPerOrder limits the number of goods in one order.
+const MaxGoodsPerOrder = 100
+
 type OrderData struct {
 	UserID   int64   `json:"user_id"`
 	GoodsIds []int64 `json:"goods_ids"`
 }
+
+// Validate returns Error describing the first invalid field.
+func (d OrderData) Validate() error {
+	if d.UserID == 0 {
+		return Error{Code: ErrCodeRequired, Message: "user_id is required", Field: "user_id"}
+	}
+	if d.UserID < 0 {
+		return Error{Code: ErrCodeInvalidValue, Message: "user_id must be positive", Field: "user_id"}
+	}
+
+	if len(d.GoodsIds) == 0 {
+		return Error{Code: ErrCodeRequired, Message: "goods_ids must contain at least one item", Field: "goods_ids"}
+	}
+	if len(d.GoodsIds) > MaxGoodsPerOrder {
+		return Error{Code: ErrCodeTooMany, Message: fmt.Sprintf("goods_ids must contain at most %d items", MaxGoodsPerOrder), Field: "goods_ids"}
+	}
+
+	seen := make(map[int64]struct{}, len(d.GoodsIds))
+	for i, goodsID := range d.GoodsIds {
+		field := fmt.Sprintf("goods_ids[%d]", i)
+		if goodsID <= 0 {
+			return Error{Code: ErrCodeInvalidValue, Message: "goods id must be positive", Field: field}
+		}
+		if _, ok := seen[goodsID]; ok {
+			return Error{Code: ErrCodeDuplicate, Message: fmt.Sprintf("goods id %d is duplicated", goodsID), Field: field}
+		}
+		seen[goodsID] = struct{}{}
+	}
+
+	return nil
+}
+
+// OrderFilter describes the orders listing request. Zero values mean "no filter".
+type OrderFilter struct {
+	UserID      int64
+	StatusID    int64
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// AfterID is the keyset cursor: only orders with a smaller id are returned.
+	AfterID int64
+	Limit   int
+}
@@ -1,28 +1,35 @@
 package monitoring
 
 import (
-	"fmt"
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
+	"github.com/kybuk_oo/example_go_metrics/platform/inbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"log"
-	"net/http"
-	"os"
 )
 
+// Metrics keeps the service and the shared metrics.
 type Metrics struct {
-	Counter   map[string]*prometheus.CounterVec
-	Gauge     map[string]prometheus.Gauge
-	Summary   map[string]prometheus.Summary
-	Histogram map[string]*prometheus.HistogramVec
+	prom.Metrics
 }
 
+// StartMetrics creates the metrics and serves them on /metrics.
 func StartMetrics() (Metrics, error) {
-	counters := Metrics{
-		Counter:   make(map[string]*prometheus.CounterVec),
-		Gauge:     make(map[string]prometheus.Gauge),
-		Summary:   make(map[string]prometheus.Summary),
-		Histogram: make(map[string]*prometheus.HistogramVec),
+	counters := NewMetrics()
+
+	var err error
+	counters.Metrics, err = prom.RunPrometheus(counters.Metrics)
+	if err != nil {
+		return counters, err
 	}
+
+	return counters, nil
+}
+
+// NewMetrics creates the metrics without registering them, e.g. for the tests.
+func NewMetrics() Metrics {
+	counters := Metrics{Metrics: prom.NewMetrics()}
 	/*
 		Counter, как несложно угадать по названию, представляет собой простой счетчик.
 		Не самый полезный тип метрики, поскольку счетчик этот является неубывающим.
@@ -39,6 +46,47 @@ func StartMetrics() (Metrics, error) {
 		Help:      "Количество вызовов запросов",
 	}, []string{"type"})
 	counters.Counter["request_send"] = createOrderSend
+	requestReplayed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_orders",
+		Name:      "request_replayed",
+		Help:      "Количество повторных запросов с уже использованным Idempotency-Key",
+	}, []string{"method"})
+	counters.Counter["request_replayed"] = requestReplayed
+	requestValidationFailed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_orders",
+		Name:      "request_validation_failed",
+		Help:      "Количество запросов, не прошедших валидацию",
+	}, []string{"method", "field", "code"})
+	counters.Counter["request_validation_failed"] = requestValidationFailed
+	outboxPublishFailed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_orders",
+		Name:      "outbox_publish_failed",
+		Help:      "Количество неудачных попыток отправки сообщений из outbox в Kafka",
+	}, []string{"topic"})
+	counters.Counter["outbox_publish_failed"] = outboxPublishFailed
+	/*
+		# HELP saga_orders Количество заказов, обработанных по таймауту саги
+		# TYPE saga_orders counter
+		saga_orders{result="expired"} 1
+		saga_orders{result="failed"} 1
+	*/
+	sagaOrders := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_orders",
+		Name:      "saga_orders",
+		Help:      "Количество заказов, обработанных по таймауту саги",
+	}, []string{"result"})
+	counters.Counter["saga_orders"] = sagaOrders
+	/*
+		# HELP saga_late_events Количество ответов goods, полученных после выхода заказа из статуса PENDING
+		# TYPE saga_late_events counter
+		saga_late_events{event="goods_rejected", status="GOODS_RESERVED"} 1
+	*/
+	sagaLateEvents := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "example_go_metrics_orders",
+		Name:      "saga_late_events",
+		Help:      "Количество ответов goods, полученных после выхода заказа из статуса PENDING",
+	}, []string{"event", "status"})
+	counters.Counter["saga_late_events"] = sagaLateEvents
 
 	/*
 		Gauge, здесь используется в значении «мера».
@@ -58,6 +106,39 @@ func StartMetrics() (Metrics, error) {
 			Help:      "Количество активных вызовов создания заказа",
 		})
 	counters.Gauge["work_order_create"] = workOrderCreate
+	outboxBacklog := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "outbox_backlog",
+			Help:      "Количество неотправленных сообщений в outbox",
+		})
+	counters.Gauge["outbox_backlog"] = outboxBacklog
+	outboxFailed := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "outbox_failed",
+			Help:      "Количество сообщений outbox, не отправленных после всех попыток",
+		})
+	counters.Gauge["outbox_failed"] = outboxFailed
+	sagaStuckOrders := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "saga_stuck_orders",
+			Help:      "Количество заказов в статусе PENDING дольше таймаута саги",
+		})
+	counters.Gauge["saga_stuck_orders"] = sagaStuckOrders
+	/*
+		# HELP requests_in_flight Количество активных запросов
+		# TYPE requests_in_flight gauge
+		requests_in_flight{method="CreateOrderV1"} 3
+	*/
+	requestsInFlight := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "requests_in_flight",
+			Help:      "Количество активных запросов",
+		}, []string{"method"})
+	counters.GaugeVec["requests_in_flight"] = requestsInFlight
 	/*
 		Histogram представляет собой гистограмму.
 		Этот тип метрики хранит число раз, которое измеряемая величина попала в заданный интервал значений (бакет).
@@ -89,6 +170,38 @@ func StartMetrics() (Metrics, error) {
 			Buckets: []float64{0.1, 0.15, 0.2, 0.25, 0.3},
 		}, []string{"status", "method"})
 	counters.Histogram["request_processing_time_histogram_ms"] = requestProcessingTimeHistogramMs
+	/*
+		# HELP http_request_duration_seconds Продолжительность обработки HTTP запроса
+		# TYPE http_request_duration_seconds histogram
+		http_request_duration_seconds_bucket{method="ListOrdersV1", route="/v1/orders", http_method="GET", status="200", le="0.1"} 1
+	*/
+	httpRequestDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "http_request_duration_seconds",
+			Help:      "Продолжительность обработки HTTP запроса",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		}, []string{"status", "method", "route", "http_method"})
+	counters.Histogram["http_request_duration_seconds"] = httpRequestDurationSeconds
+
+	responseSizeBytes := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "response_size_bytes",
+			Help:      "Размер тела ответа в байтах",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 6),
+		}, []string{"status", "method", "route", "http_method"})
+	counters.Histogram["response_size_bytes"] = responseSizeBytes
+
+	outboxRelayLatencySeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "example_go_metrics_orders",
+			Name:      "outbox_relay_latency_seconds",
+			Help:      "Время от записи сообщения в outbox до его отправки в Kafka",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"topic"})
+	counters.Histogram["outbox_relay_latency_seconds"] = outboxRelayLatencySeconds
+
 	/*
 		Summary честно считает заданные процентили.
 		Идеально подходит для измерения времени ответа или чего-то такого.
@@ -124,64 +237,10 @@ func StartMetrics() (Metrics, error) {
 		Отлично подходят в случаях, когда вам нужно измерить время ответа сервера в зависимости от запроса, или вроде того.
 	*/
 
-	metricsProm, err := RunPrometheus(counters)
-	if err != nil {
-		return metricsProm, err
-	}
-
-	return metricsProm, nil
-}
-
-func RunPrometheus(metrics Metrics) (Metrics, error) {
-	var err error
-
-	fmt.Println("start server metrics...")
-
-	if len(metrics.Counter) > 0 {
-		for _, counter := range metrics.Counter {
-			////	prometheus.MustRegister(counter)
-			err = prometheus.Register(counter)
-			if err != nil {
-				return Metrics{}, err
-			}
-		}
-	}
-
-	if len(metrics.Gauge) > 0 {
-		for _, gauge := range metrics.Gauge {
-			////	prometheus.MustRegister(counter)
-			err = prometheus.Register(gauge)
-			if err != nil {
-				return Metrics{}, err
-			}
-		}
-	}
-
-	if len(metrics.Summary) > 0 {
-		for _, summary := range metrics.Summary {
-			////	prometheus.MustRegister(counter)
-			err = prometheus.Register(summary)
-			if err != nil {
-				return Metrics{}, err
-			}
-		}
-	}
-
-	if len(metrics.Histogram) > 0 {
-		for _, histogram := range metrics.Histogram {
-			////	prometheus.MustRegister(counter)
-			err = prometheus.Register(histogram)
-			if err != nil {
-				return Metrics{}, err
-			}
-		}
-	}
-
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		err = http.ListenAndServe(":"+os.Getenv("METRICS_PORT"), nil)
-		log.Println(err)
-	}()
+	health.AddMetrics(counters.Metrics, "example_go_metrics_orders")
+	inbox.AddMetrics(counters.Metrics, "example_go_metrics_orders")
+	retention.AddMetrics(counters.Metrics, "example_go_metrics_orders")
+	kafka.AddMetrics(counters.Metrics, "example_go_metrics_orders")
 
-	return metrics, nil
+	return counters
 }
@@ -0,0 +1,100 @@
+package monitoring
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// legacyRoute is the only route of the dashboard metrics.
+const legacyRoute = "CreateOrderV1"
+
+// skippedRoutes are the probes, they aren't instrumented.
+var skippedRoutes = map[string]bool{
+	"Healthz": true,
+	"Readyz":  true,
+}
+
+// Middleware records the HTTP metrics of every route.
+func (m Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, route := routeLabels(r)
+		if skippedRoutes[name] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		inFlight := m.GaugeVec["requests_in_flight"].With(prometheus.Labels{"method": name})
+		inFlight.Inc()
+		defer inFlight.Dec()
+		if name == legacyRoute {
+			m.Gauge["work_order_create"].Inc()
+			defer m.Gauge["work_order_create"].Dec()
+		}
+
+		now := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		elapsed := time.Since(now).Seconds()
+
+		labels := prometheus.Labels{
+			"method":      name,
+			"route":       route,
+			"http_method": r.Method,
+			"status":      strconv.Itoa(rw.status),
+		}
+		m.Histogram["http_request_duration_seconds"].With(labels).Observe(elapsed)
+		m.Histogram["response_size_bytes"].With(labels).Observe(float64(rw.size))
+		if name == legacyRoute {
+			m.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{
+				"method": name,
+				"status": labels["status"],
+			}).Observe(elapsed)
+			m.Summary["request_processing_time_summary_ms"].Observe(elapsed)
+		}
+	})
+}
+
+func routeLabels(r *http.Request) (name string, template string) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unknown", "unknown"
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		template = "unknown"
+	}
+	name = route.GetName()
+	if name == "" {
+		name = template
+	}
+
+	return name, template
+}
+
+// responseWriter remembers the status code and the number of written bytes.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+
+	return n, err
+}
@@ -0,0 +1,51 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMiddleware(t *testing.T) {
+	metrics := NewMetrics()
+	router := mux.NewRouter()
+	router.Use(metrics.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/v1/orders", ok).Methods(http.MethodPost).Name("CreateOrderV1")
+	router.HandleFunc("/v1/orders", ok).Methods(http.MethodGet).Name("ListOrdersV1")
+	router.HandleFunc("/healthz", ok).Methods(http.MethodGet).Name("Healthz")
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/v1/orders", nil),
+		httptest.NewRequest(http.MethodGet, "/v1/orders", nil),
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if n := testutil.CollectAndCount(metrics.Histogram["http_request_duration_seconds"]); n != 2 {
+		t.Fatalf("http_request_duration_seconds has %d series, the API routes without the probe are expected", n)
+	}
+	if n := testutil.CollectAndCount(metrics.Histogram["request_processing_time_histogram_ms"]); n != 1 {
+		t.Fatalf("request_processing_time_histogram_ms has %d series, only CreateOrderV1 is expected", n)
+	}
+	if count := summaryCount(t, metrics); count != 1 {
+		t.Fatalf("request_processing_time_summary_ms observed %d requests, only CreateOrderV1 is expected", count)
+	}
+}
+
+func summaryCount(t *testing.T, metrics Metrics) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	err := metrics.Summary["request_processing_time_summary_ms"].Write(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m.GetSummary().GetSampleCount()
+}
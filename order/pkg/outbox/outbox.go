@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+)
+
+// Message is a Kafka message stored in the outbox table until the relay publishes it.
+type Message struct {
+	Topic   string
+	Key     string
+	Payload []byte
+	Headers map[string]string
+}
+
+// NewEventMessage encodes the event as the message payload, the payload has to match the schema.
+func NewEventMessage(topic, schema string, event interface{}) (Message, error) {
+	payload, err := events.Marshal(schema, event)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{Topic: topic, Payload: payload}, nil
+}
+
+// Enqueue stores the message in the transaction of the business change,
+// so the message is published if and only if the change is committed.
+// The request ID and the trace context of ctx are added to the message headers.
+func Enqueue(ctx context.Context, tx pgx.Tx, msg Message) error {
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		headers[requestid.KafkaHeader] = id
+	}
+	tracing.InjectHeaders(ctx, headers)
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO outbox (topic, key, payload, headers, created_at) VALUES ($1, $2, $3, $4, NOW())`,
+		msg.Topic, msg.Key, string(msg.Payload), string(headersJSON))
+
+	return err
+}
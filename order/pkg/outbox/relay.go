@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// relayLockID is the key of the Postgres advisory lock, so only one replica relays at a time.
+	relayLockID = 7_326_002
+
+	defaultPollInterval = 500 * time.Millisecond
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultRetention    = 24 * time.Hour
+	maxBackoff          = 30 * time.Second
+)
+
+// errEmptyTopic is returned for a message stored without the topic, e.g. when its env var isn't set.
+var errEmptyTopic = errors.New("outbox message has no topic")
+
+// Relay publishes the outbox messages to Kafka in the order they were stored. Only the replica holding
+// the advisory lock publishes. A failed message holds back the later messages with the same key,
+// after OUTBOX_MAX_ATTEMPTS it is parked (failed_at) and counted in outbox_failed.
+type Relay struct {
+	db           *pgxpool.Pool
+	producer     sarama.SyncProducer
+	metrics      monitoring.Metrics
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+}
+
+func NewRelay(db *pgxpool.Pool, producer sarama.SyncProducer, metrics monitoring.Metrics) Relay {
+	return Relay{
+		db:           db,
+		producer:     producer,
+		metrics:      metrics,
+		pollInterval: config.Duration("OUTBOX_POLL_INTERVAL", defaultPollInterval),
+		batchSize:    config.Int("OUTBOX_BATCH_SIZE", defaultBatchSize, 1),
+		maxAttempts:  config.Int("OUTBOX_MAX_ATTEMPTS", defaultMaxAttempts, 1),
+	}
+}
+
+// Run polls the outbox until ctx is done. While Kafka is unavailable the delay
+// before the next poll doubles up to maxBackoff.
+func (r Relay) Run(ctx context.Context) {
+	delay := r.pollInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		err := r.relayBatch(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Outbox messages haven't been relayed.")
+			delay *= 2
+			if delay > maxBackoff {
+				delay = maxBackoff
+			}
+		} else {
+			delay = r.pollInterval
+		}
+
+		r.updateBacklog(ctx)
+	}
+}
+
+type row struct {
+	id        int64
+	message   Message
+	attempts  int
+	waiting   bool
+	createdAt time.Time
+}
+
+// RetentionPolicy deletes the messages sent longer than OUTBOX_RETENTION ago. The parked messages are kept.
+func RetentionPolicy() retention.Policy {
+	return retention.Policy{Table: "outbox", Column: "sent_at", TTL: config.Duration("OUTBOX_RETENTION", defaultRetention)}
+}
+
+// relayBatch publishes one batch of unsent messages. The advisory lock is held by the connection,
+// so no transaction stays open while the messages are sent, every row is updated right after its send.
+// A failure of Kafka itself stops the batch, a failure of one message holds back only its key.
+func (r Relay) relayBatch(ctx context.Context) error {
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, relayLockID).Scan(&locked)
+	if err != nil || !locked {
+		return err
+	}
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, relayLockID)
+		if err != nil {
+			// Closing the connection releases the lock.
+			_ = conn.Conn().Close(context.Background())
+		}
+	}()
+
+	batch, err := r.selectBatch(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]bool)
+	for _, item := range batch {
+		key := item.message.Key
+		if key != "" && blocked[key] {
+			continue
+		}
+		if item.waiting {
+			blocked[key] = true
+			continue
+		}
+
+		sendErr := r.send(ctx, item.message)
+		if sendErr == nil {
+			_, err = conn.Exec(ctx, `UPDATE outbox SET attempts = attempts + 1, sent_at = NOW() WHERE id = $1`, item.id)
+			if err != nil {
+				return err
+			}
+			r.metrics.Histogram["outbox_relay_latency_seconds"].With(prometheus.Labels{"topic": item.message.Topic}).Observe(time.Since(item.createdAt).Seconds())
+			continue
+		}
+
+		r.metrics.Counter["outbox_publish_failed"].With(prometheus.Labels{"topic": item.message.Topic}).Inc()
+		if !permanent(sendErr) {
+			_, err = conn.Exec(ctx, `UPDATE outbox SET last_error = $1 WHERE id = $2`, sendErr.Error(), item.id)
+			if err != nil {
+				return err
+			}
+			return sendErr
+		}
+
+		blocked[key] = true
+		err = r.failed(ctx, conn, item, sendErr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r Relay) selectBatch(ctx context.Context, conn *pgxpool.Conn) ([]row, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT id, topic, key, payload, headers, attempts, COALESCE(retry_at > NOW(), FALSE), created_at
+		FROM outbox
+		WHERE sent_at IS NULL AND failed_at IS NULL
+		ORDER BY id
+		LIMIT $1`, r.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []row
+	for rows.Next() {
+		var payload string
+		item := row{}
+		err := rows.Scan(&item.id, &item.message.Topic, &item.message.Key, &payload, &item.message.Headers,
+			&item.attempts, &item.waiting, &item.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		item.message.Payload = []byte(payload)
+		batch = append(batch, item)
+	}
+
+	return batch, rows.Err()
+}
+
+// failed schedules the next attempt of the message or parks it after the last one.
+func (r Relay) failed(ctx context.Context, conn *pgxpool.Conn, item row, sendErr error) error {
+	attempts := item.attempts + 1
+	logger := log.Error().Err(sendErr).Int64("outbox_id", item.id).Str("topic", item.message.Topic).Int("attempt", attempts)
+	if attempts >= r.maxAttempts {
+		_, err := conn.Exec(ctx, `UPDATE outbox SET attempts = $1, last_error = $2, failed_at = NOW() WHERE id = $3`,
+			attempts, sendErr.Error(), item.id)
+		if err == nil {
+			logger.Msg("Outbox message has been parked.")
+		}
+		return err
+	}
+
+	retryIn := r.pollInterval << attempts
+	if retryIn <= 0 || retryIn > maxBackoff {
+		retryIn = maxBackoff
+	}
+	_, err := conn.Exec(ctx, `UPDATE outbox SET attempts = $1, last_error = $2, retry_at = NOW() + $3 * INTERVAL '1 millisecond' WHERE id = $4`,
+		attempts, sendErr.Error(), retryIn.Milliseconds(), item.id)
+	if err == nil {
+		logger.Msg("Outbox message hasn't been published.")
+	}
+
+	return err
+}
+
+// permanent reports whether the message itself can't be published, unlike an unavailable Kafka.
+func permanent(err error) bool {
+	var configErr sarama.ConfigurationError
+
+	return errors.Is(err, errEmptyTopic) || errors.As(err, &configErr) ||
+		errors.Is(err, sarama.ErrMessageSizeTooLarge) || errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidRecord) || errors.Is(err, sarama.ErrInvalidTopic) ||
+		errors.Is(err, sarama.ErrUnknownTopicOrPartition) || errors.Is(err, sarama.ErrTopicAuthorizationFailed)
+}
+
+// send publishes the message in a producer span continuing the trace of the request that stored it.
+func (r Relay) send(ctx context.Context, msg Message) (err error) {
+	if msg.Topic == "" {
+		return errEmptyTopic
+	}
+	producerMsg := &sarama.ProducerMessage{Topic: msg.Topic, Value: sarama.ByteEncoder(msg.Payload)}
+	if msg.Key != "" {
+		producerMsg.Key = sarama.StringEncoder(msg.Key)
+	}
+	for key, value := range msg.Headers {
+		producerMsg.Headers = append(producerMsg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+
+	_, span := tracing.StartProducerSpan(tracing.ExtractHeaders(ctx, msg.Headers), producerMsg)
+	defer func() { tracing.End(span, err) }()
+	_, _, err = r.producer.SendMessage(producerMsg)
+
+	return err
+}
+
+func (r Relay) updateBacklog(ctx context.Context) {
+	var backlog, failed int64
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE failed_at IS NULL), COUNT(*) FILTER (WHERE failed_at IS NOT NULL)
+		FROM outbox
+		WHERE sent_at IS NULL`).Scan(&backlog, &failed)
+	if err != nil {
+		log.Error().Err(err).Msg("Outbox backlog hasn't been counted.")
+		return
+	}
+	r.metrics.Gauge["outbox_backlog"].Set(float64(backlog))
+	r.metrics.Gauge["outbox_failed"].Set(float64(failed))
+}
@@ -0,0 +1,128 @@
+//go:build integration
+// +build integration
+
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/testdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestRelay(db *pgxpool.Pool, producer sarama.SyncProducer) Relay {
+	return Relay{
+		db:           db,
+		producer:     producer,
+		metrics:      monitoring.NewMetrics(),
+		pollInterval: time.Millisecond,
+		batchSize:    10,
+		maxAttempts:  2,
+	}
+}
+
+func enqueue(t *testing.T, db *pgxpool.Pool, messages ...Message) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	for _, msg := range messages {
+		err = Enqueue(ctx, tx, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayParksFailedMessageAndHoldsBackItsKeyOnly(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	relay := newTestRelay(db, producer)
+	ctx := context.Background()
+	// The first message of order 1 can't be published at all, order 2 isn't affected.
+	enqueue(t, db,
+		Message{Key: "1", Payload: []byte(`{}`)},
+		Message{Topic: "order_cancelled_v1", Key: "1", Payload: []byte(`{}`)},
+		Message{Topic: "order_created_v1", Key: "2", Payload: []byte(`{}`)},
+	)
+
+	producer.ExpectSendMessageAndSucceed()
+	err := relay.relayBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE sent_at IS NOT NULL`); sent != 1 {
+		t.Fatalf("%d messages are sent, only the one of order 2 is expected", sent)
+	}
+	if waiting := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE key = '1' AND attempts = 1 AND retry_at IS NOT NULL`); waiting != 1 {
+		t.Fatal("failed message isn't scheduled for the next attempt")
+	}
+
+	// The last attempt parks the message, the next batch publishes the rest of order 1.
+	_, err = db.Exec(ctx, `UPDATE outbox SET retry_at = NULL`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = relay.relayBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer.ExpectSendMessageAndSucceed()
+	err = relay.relayBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parked := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE failed_at IS NOT NULL AND sent_at IS NULL AND attempts = 2`); parked != 1 {
+		t.Fatalf("%d messages are parked, 1 is expected", parked)
+	}
+	if sent := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE sent_at IS NOT NULL`); sent != 2 {
+		t.Fatalf("%d messages are sent, 2 are expected", sent)
+	}
+	relay.updateBacklog(ctx)
+	if failed := testutil.ToFloat64(relay.metrics.Gauge["outbox_failed"]); failed != 1 {
+		t.Fatalf("outbox_failed = %v, 1 is expected", failed)
+	}
+	if backlog := testutil.ToFloat64(relay.metrics.Gauge["outbox_backlog"]); backlog != 0 {
+		t.Fatalf("outbox_backlog = %v, the parked message isn't expected", backlog)
+	}
+}
+
+func TestRelayStopsWhileKafkaIsUnavailable(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	relay := newTestRelay(db, producer)
+	enqueue(t, db,
+		Message{Topic: "order_created_v1", Key: "1", Payload: []byte(`{}`)},
+		Message{Topic: "order_created_v1", Key: "2", Payload: []byte(`{}`)},
+	)
+
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	err := relay.relayBatch(context.Background())
+	if err == nil {
+		t.Fatal("Kafka failure has to be returned")
+	}
+
+	// Neither message is given up on: the attempts count the failures of the message itself.
+	if untouched := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL AND attempts = 0 AND retry_at IS NULL`); untouched != 2 {
+		t.Fatalf("%d messages are left for the next batch, 2 are expected", untouched)
+	}
+	if latency := testutil.CollectAndCount(relay.metrics.Histogram["outbox_relay_latency_seconds"]); latency != 0 {
+		t.Fatal("latency of unsent messages is observed")
+	}
+}
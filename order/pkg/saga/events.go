@@ -0,0 +1,52 @@
+package saga
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/outbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+)
+
+// OrderCreatedMessage builds order_created of the version set by ORDER_CREATED_VERSION:
+// 2 is published to ORDER_CREATED_V2_TOPIC, otherwise v1 is published to ORDER_CREATED_TOPIC.
+// Goods consumes both topics, so the version can be switched without downtime.
+func OrderCreatedMessage(orderID int64, goodsIds []int64, createdAt time.Time) (outbox.Message, error) {
+	if config.Int("ORDER_CREATED_VERSION", 1, 1) == 2 {
+		return newOrderMessage(os.Getenv("ORDER_CREATED_V2_TOPIC"), events.SchemaOrderCreatedV2, events.OrderCreated, orderID, events.OrderCreatedV2{
+			OrderID:   orderID,
+			GoodsIds:  goodsIds,
+			CreatedAt: createdAt,
+		})
+	}
+
+	return newOrderMessage(os.Getenv("ORDER_CREATED_TOPIC"), events.SchemaOrderCreatedV1, events.OrderCreated, orderID, events.OrderCreatedV1{Data: events.Order{
+		ID:       orderID,
+		GoodsIds: goodsIds,
+	}})
+}
+
+// OrderCancelledMessage builds order_cancelled_v1.
+func OrderCancelledMessage(orderID int64, goodsIds []int64) (outbox.Message, error) {
+	return newOrderMessage(os.Getenv("ORDER_CANCELLED_TOPIC"), events.SchemaOrderCancelledV1, events.OrderCancelled, orderID, events.OrderCancelledV1{Data: events.Order{
+		ID:       orderID,
+		GoodsIds: goodsIds,
+	}})
+}
+
+// newOrderMessage keys the event by the order ID, so all the events of the order get into
+// one partition and are consumed in the order they were published. The event ID is the same
+// for every copy of the event, e.g. republished by the sweeper, so goods skips the duplicates.
+func newOrderMessage(topic, schema, name string, orderID int64, event interface{}) (outbox.Message, error) {
+	msg, err := outbox.NewEventMessage(topic, schema, event)
+	if err != nil {
+		return msg, err
+	}
+	msg.Key = strconv.FormatInt(orderID, 10)
+	msg.Headers = map[string]string{kafka.HeaderEventID: events.ID(name, orderID)}
+
+	return msg, nil
+}
@@ -0,0 +1,144 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// State of the order saga. The values are the ids of the rows in the statuses table.
+type State int64
+
+const (
+	Pending       State = 1
+	GoodsReserved State = 2
+	Cancelled     State = 3
+	Rejected      State = 4
+	Expired       State = 5
+)
+
+var stateNames = map[State]string{
+	Pending:       "PENDING",
+	GoodsReserved: "GOODS_RESERVED",
+	Cancelled:     "CANCELLED",
+	Rejected:      "REJECTED",
+	Expired:       "EXPIRED",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("UNKNOWN(%d)", int64(s))
+}
+
+// ParseState returns the state by its name, e.g. PENDING.
+func ParseState(name string) (State, bool) {
+	for state, stateName := range stateNames {
+		if stateName == name {
+			return state, true
+		}
+	}
+
+	return 0, false
+}
+
+// StateNames returns the names of all the states in the order of their ids.
+func StateNames() []string {
+	names := make([]string, 0, len(stateNames))
+	for state := Pending; state <= Expired; state++ {
+		names = append(names, stateNames[state])
+	}
+
+	return names
+}
+
+// transitions lists the legal moves. REJECTED, CANCELLED and EXPIRED are final.
+var transitions = map[State][]State{
+	Pending:       {GoodsReserved, Rejected, Cancelled, Expired},
+	GoodsReserved: {Cancelled},
+}
+
+// Names of the events triggering the transitions.
+const (
+	EventOrderCreated   = "order_created"
+	EventOrderCancelled = "order_cancelled"
+	EventGoodsReserved  = "goods_created"
+	EventGoodsRejected  = "goods_rejected"
+)
+
+// Event is the reason of the transition and the time it happened,
+// e.g. the timestamp of the Kafka message.
+type Event struct {
+	Name       string
+	OccurredAt time.Time
+}
+
+// IllegalTransitionError is returned when the order can't move from its current state.
+type IllegalTransitionError struct {
+	OrderID int64
+	From    State
+	To      State
+}
+
+func (e IllegalTransitionError) Error() string {
+	return fmt.Sprintf("order %d can't move from %s to %s", e.OrderID, e.From, e.To)
+}
+
+func CanTransition(from, to State) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start records the initial PENDING state of the order created in tx.
+func Start(ctx context.Context, tx pgx.Tx, orderID int64, event Event) error {
+	return recordTransition(ctx, tx, orderID, nil, Pending, event)
+}
+
+// Transition locks the order, checks that the move is legal, updates the status
+// and records the move in order_status_history. All of it happens in tx.
+// It returns pgx.ErrNoRows for an unknown order and IllegalTransitionError for an illegal move.
+func Transition(ctx context.Context, tx pgx.Tx, orderID int64, to State, event Event) error {
+	var statusID int64
+	err := tx.QueryRow(ctx, `SELECT status_id FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&statusID)
+	if err != nil {
+		return err
+	}
+	from := State(statusID)
+	if !CanTransition(from, to) {
+		return IllegalTransitionError{OrderID: orderID, From: from, To: to}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE orders SET status_id = $1, updated_at = NOW() WHERE id = $2`, int64(to), orderID)
+	if err != nil {
+		return err
+	}
+
+	return recordTransition(ctx, tx, orderID, &from, to, event)
+}
+
+func recordTransition(ctx context.Context, tx pgx.Tx, orderID int64, from *State, to State, event Event) error {
+	var fromID *int64
+	if from != nil {
+		id := int64(*from)
+		fromID = &id
+	}
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, from_status_id, to_status_id, event, event_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())`, orderID, fromID, int64(to), event.Name, occurredAt.UTC())
+
+	return err
+}
@@ -0,0 +1,248 @@
+package saga
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/outbox"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+)
+
+const (
+	EventTimeout = "saga_timeout"
+
+	// sweeperLockID is the key of the Postgres advisory lock, so only one replica sweeps at a time.
+	sweeperLockID = 7_326_001
+
+	sweepBatchSize = 100
+
+	// Results of the swept orders, they are used as the result label of saga_orders.
+	resultRepublished = "republished"
+	resultExpired     = "expired"
+	resultFailed      = "failed"
+
+	defaultPendingTimeout = 5 * time.Minute
+	defaultSweepInterval  = 30 * time.Second
+	defaultMaxRepublish   = 2
+)
+
+// Sweeper finds the orders staying PENDING longer than the timeout, e.g. because order_created_v1
+// was lost or the goods consumer is down. Such an order is republished up to maxRepublish times
+// and then moves to EXPIRED. order_cancelled_v1 is sent for the expired order: goods releases the goods
+// reserved by any of the published order_created_v1 and doesn't reserve them for the ones it processes
+// after the cancellation, e.g. when the goods consumer comes back and reads both topics at once.
+type Sweeper struct {
+	db             *pgxpool.Pool
+	metrics        monitoring.Metrics
+	pendingTimeout time.Duration
+	interval       time.Duration
+	maxRepublish   int
+}
+
+func NewSweeper(db *pgxpool.Pool, metrics monitoring.Metrics) Sweeper {
+	return Sweeper{
+		db:             db,
+		metrics:        metrics,
+		pendingTimeout: config.Duration("SAGA_PENDING_TIMEOUT", defaultPendingTimeout),
+		interval:       config.Duration("SAGA_SWEEP_INTERVAL", defaultSweepInterval),
+		maxRepublish:   config.Int("SAGA_MAX_REPUBLISH", defaultMaxRepublish, 0),
+	}
+}
+
+// Run sweeps every interval until ctx is done.
+func (s Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// The events published by the sweep get their own request ID.
+		sweepCtx := requestid.NewContext(ctx, requestid.New())
+		err := s.sweep(sweepCtx)
+		if err != nil {
+			requestid.Logger(sweepCtx).Error().Err(err).Msg("Pending orders haven't been swept.")
+		}
+	}
+}
+
+type stuckOrder struct {
+	id             int64
+	republishCount int
+	createdAt      time.Time
+}
+
+func (s Sweeper) sweep(ctx context.Context) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "sweepPendingOrders")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	err = tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, sweeperLockID).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		// Another replica is sweeping right now.
+		return nil
+	}
+
+	deadline := time.Now().UTC().Add(-s.pendingTimeout)
+	var stuck int64
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM orders WHERE status_id = $1 AND COALESCE(republished_at, created_at) < $2`,
+		int64(Pending), deadline).Scan(&stuck)
+	if err != nil {
+		return err
+	}
+	s.metrics.Gauge["saga_stuck_orders"].Set(float64(stuck))
+
+	orders, err := selectStuckOrders(ctx, tx, deadline)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]float64)
+	for _, order := range orders {
+		result, err := s.sweepOrder(ctx, tx, order)
+		if err != nil {
+			requestid.Logger(ctx).Error().Err(err).Int64("order_id", order.id).Msg("Pending order hasn't been swept.")
+			// The order is swept again after the timeout, so the failing orders don't fill every batch.
+			_, err = tx.Exec(ctx, `UPDATE orders SET republished_at = NOW() WHERE id = $1`, order.id)
+			if err != nil {
+				return err
+			}
+		}
+		counts[result]++
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	for result, count := range counts {
+		s.metrics.Counter["saga_orders"].WithLabelValues(result).Add(count)
+	}
+
+	return nil
+}
+
+// sweepOrder republishes or expires the order in a savepoint, so a failed order doesn't roll back the others.
+func (s Sweeper) sweepOrder(ctx context.Context, tx pgx.Tx, order stuckOrder) (string, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return resultFailed, err
+	}
+	defer savepoint.Rollback(ctx)
+
+	result := resultRepublished
+	if order.republishCount < s.maxRepublish {
+		err = republish(ctx, savepoint, order)
+	} else {
+		result = resultExpired
+		err = expire(ctx, savepoint, order.id)
+	}
+	if err == nil {
+		err = savepoint.Commit(ctx)
+	}
+	if err != nil {
+		return resultFailed, err
+	}
+
+	return result, nil
+}
+
+func selectStuckOrders(ctx context.Context, tx pgx.Tx, deadline time.Time) ([]stuckOrder, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, republish_count, created_at
+		FROM orders
+		WHERE status_id = $1 AND COALESCE(republished_at, created_at) < $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE`, int64(Pending), deadline, sweepBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []stuckOrder
+	for rows.Next() {
+		order := stuckOrder{}
+		if err := rows.Scan(&order.id, &order.republishCount, &order.createdAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func republish(ctx context.Context, tx pgx.Tx, order stuckOrder) error {
+	goodsIds, err := selectGoodsIds(ctx, tx, order.id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE orders SET republish_count = republish_count + 1, republished_at = NOW() WHERE id = $1`, order.id)
+	if err != nil {
+		return err
+	}
+
+	msg, err := OrderCreatedMessage(order.id, goodsIds, order.createdAt)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Enqueue(ctx, tx, msg)
+}
+
+func expire(ctx context.Context, tx pgx.Tx, orderID int64) error {
+	err := Transition(ctx, tx, orderID, Expired, Event{Name: EventTimeout})
+	if err != nil {
+		return err
+	}
+
+	goodsIds, err := selectGoodsIds(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	msg, err := OrderCancelledMessage(orderID, goodsIds)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Enqueue(ctx, tx, msg)
+}
+
+func selectGoodsIds(ctx context.Context, tx pgx.Tx, orderID int64) ([]int64, error) {
+	rows, err := tx.Query(ctx, `SELECT goods_id FROM order_goods WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goodsIds := []int64{}
+	for rows.Next() {
+		var goodsID int64
+		if err := rows.Scan(&goodsID); err != nil {
+			return nil, err
+		}
+		goodsIds = append(goodsIds, goodsID)
+	}
+
+	return goodsIds, rows.Err()
+}
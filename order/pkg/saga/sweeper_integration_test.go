@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package saga
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
+	"github.com/kybuk_oo/example_go_metrics/platform/testdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// insertStuckOrder creates a PENDING order older than the saga timeout.
+func insertStuckOrder(t *testing.T, db *pgxpool.Pool, goodsIds ...int64) int64 {
+	t.Helper()
+	ctx := context.Background()
+	var orderID int64
+	err := db.QueryRow(ctx, `INSERT INTO orders (user_id, status_id, created_at) VALUES (1, $1, NOW() - INTERVAL '1 hour') RETURNING id`,
+		int64(Pending)).Scan(&orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, goodsID := range goodsIds {
+		_, err = db.Exec(ctx, `INSERT INTO order_goods (order_id, goods_id) VALUES ($1, $2)`, orderID, goodsID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return orderID
+}
+
+func TestSweepSkipsFailingOrder(t *testing.T) {
+	db := testdb.New(t, "../../migrations")
+	t.Setenv("ORDER_CANCELLED_TOPIC", "order_cancelled_v1")
+	metrics := monitoring.NewMetrics()
+	sweeper := Sweeper{db: db, metrics: metrics, pendingTimeout: time.Minute, maxRepublish: 0}
+	expired := insertStuckOrder(t, db, 1, 2)
+	// goods_id 0 violates the event schema, so the order can't be expired.
+	failing := insertStuckOrder(t, db, 0)
+
+	err := sweeper.sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testdb.Count(t, db, `SELECT COUNT(*) FROM orders WHERE id = $1 AND status_id = $2`, expired, int64(Expired)); n != 1 {
+		t.Fatal("order isn't expired because of the failing one")
+	}
+	if n := testdb.Count(t, db, `SELECT COUNT(*) FROM outbox WHERE key = $1`, strconv.FormatInt(expired, 10)); n != 1 {
+		t.Fatalf("%d order_cancelled_v1 of the expired order are enqueued, 1 is expected", n)
+	}
+	if n := testdb.Count(t, db, `SELECT COUNT(*) FROM orders WHERE id = $1 AND status_id = $2 AND republished_at > NOW() - INTERVAL '1 minute'`, failing, int64(Pending)); n != 1 {
+		t.Fatal("failing order isn't postponed until the next timeout")
+	}
+	for result, want := range map[string]float64{resultExpired: 1, resultFailed: 1, resultRepublished: 0} {
+		if got := testutil.ToFloat64(metrics.Counter["saga_orders"].WithLabelValues(result)); got != want {
+			t.Errorf("saga_orders{result=%q} = %v, %v is expected", result, got, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errInternal      = model.Error{Code: model.ErrCodeInternal, Message: "internal server error"}
+	errOrderNotFound = model.Error{Code: model.ErrCodeNotFound, Message: "order not found"}
+	errInvalidID     = model.Error{Code: model.ErrCodeInvalidValue, Message: "order id must be a positive integer", Field: "id"}
+)
+
+// writeJSON responds with the status code and v marshaled to JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Msg("Response hasn't been marshaled.")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// writeError responds with the status code and the JSON error body.
+func writeError(w http.ResponseWriter, status int, apiErr model.Error) {
+	writeJSON(w, status, apiErr)
+}
+
+// rejectInvalidRequest responds to a request failed reading, decoding or validation.
+// Such failures are counted by field and code in request_validation_failed.
+func (s Server) rejectInvalidRequest(w http.ResponseWriter, method string, err error) {
+	apiErr := model.Error{Code: model.ErrCodeInvalidBody, Message: err.Error()}
+	errors.As(err, &apiErr)
+
+	status := http.StatusBadRequest
+	if apiErr.Code == model.ErrCodeBodyTooLarge {
+		status = http.StatusRequestEntityTooLarge
+	}
+	writeError(w, status, apiErr)
+
+	// goods_ids[3] -> goods_ids, to keep the label cardinality bounded.
+	field := apiErr.Field
+	if i := strings.IndexByte(field, '['); i >= 0 {
+		field = field[:i]
+	}
+	s.metrics.Counter["request_validation_failed"].With(prometheus.Labels{"method": method, "field": field, "code": apiErr.Code}).Inc()
+}
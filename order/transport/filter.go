@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// parseOrderFilter reads the listing filter from the query string:
+// user_id, status, created_from, created_to (RFC 3339), limit and cursor.
+func parseOrderFilter(query url.Values) (model.OrderFilter, error) {
+	filter := model.OrderFilter{Limit: defaultListLimit}
+	var err error
+
+	if v := query.Get("user_id"); v != "" {
+		filter.UserID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || filter.UserID <= 0 {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: "user_id must be a positive integer", Field: "user_id"}
+		}
+	}
+
+	if v := query.Get("status"); v != "" {
+		state, ok := saga.ParseState(v)
+		if !ok {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: "status must be one of " + strings.Join(saga.StateNames(), ", "), Field: "status"}
+		}
+		filter.StatusID = int64(state)
+	}
+
+	if v := query.Get("created_from"); v != "" {
+		filter.CreatedFrom, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: "created_from must be an RFC 3339 time", Field: "created_from"}
+		}
+	}
+
+	if v := query.Get("created_to"); v != "" {
+		filter.CreatedTo, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: "created_to must be an RFC 3339 time", Field: "created_to"}
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxListLimit {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: fmt.Sprintf("limit must be between 1 and %d", maxListLimit), Field: "limit"}
+		}
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		filter.AfterID, err = decodeCursor(v)
+		if err != nil {
+			return filter, model.Error{Code: model.ErrCodeInvalidValue, Message: err.Error(), Field: "cursor"}
+		}
+	}
+
+	return filter, nil
+}
+
+func encodeCursor(orderID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(orderID, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	orderID, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || orderID <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+
+	return orderID, nil
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+)
+
+func TestParseOrderFilterStatus(t *testing.T) {
+	tests := []struct {
+		status       string
+		wantStatusID int64
+		wantField    string
+	}{
+		{status: ""},
+		{status: "PENDING", wantStatusID: int64(saga.Pending)},
+		{status: "EXPIRED", wantStatusID: int64(saga.Expired)},
+		{status: "pending", wantField: "status"},
+		{status: "SHIPPED", wantField: "status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			filter, err := parseOrderFilter(url.Values{"status": {tt.status}})
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if filter.StatusID != tt.wantStatusID {
+					t.Fatalf("status id = %d, want %d", filter.StatusID, tt.wantStatusID)
+				}
+				return
+			}
+
+			var apiErr model.Error
+			if !errors.As(err, &apiErr) || apiErr.Field != tt.wantField {
+				t.Fatalf("error = %v, want error of field %s", err, tt.wantField)
+			}
+		})
+	}
+}
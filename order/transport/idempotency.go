@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+var (
+	// errIdempotencyKeyReused is returned when the key is already bound to a request with another body.
+	errIdempotencyKeyReused = errors.New("idempotency key has already been used with a different request body")
+	// errIdempotencyKeyTaken is returned when a concurrent request stored the same key first.
+	errIdempotencyKeyTaken = errors.New("idempotency key has been stored by a concurrent request")
+)
+
+// idempotencyKey binds the Idempotency-Key header value to the hash of the request body.
+type idempotencyKey struct {
+	Key         string
+	RequestHash string
+}
+
+func newIdempotencyKey(key string, body []byte) idempotencyKey {
+	if key == "" {
+		return idempotencyKey{}
+	}
+	hash := sha256.Sum256(body)
+
+	return idempotencyKey{Key: key, RequestHash: hex.EncodeToString(hash[:])}
+}
+
+// findIdempotentOrder returns the order created earlier with the same key.
+// Expired keys are removed, so the request is processed again.
+func (s Server) findIdempotentOrder(ctx context.Context, key idempotencyKey) (int64, bool, error) {
+	_, err := s.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= NOW()`, key.Key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var orderID int64
+	var requestHash string
+	err = s.db.QueryRow(ctx, `SELECT order_id, request_hash FROM idempotency_keys WHERE key = $1`, key.Key).Scan(&orderID, &requestHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if requestHash != key.RequestHash {
+		return 0, false, errIdempotencyKeyReused
+	}
+
+	return orderID, true, nil
+}
+
+// storeIdempotencyKey saves the key in the transaction creating the order.
+func (s Server) storeIdempotencyKey(ctx context.Context, tx pgx.Tx, key idempotencyKey, orderID int64) error {
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, order_id, created_at, expires_at)
+		VALUES ($1, $2, $3, NOW(), NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (key) DO NOTHING`, key.Key, key.RequestHash, orderID, int64(s.idempotencyKeyTTL.Seconds()))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errIdempotencyKeyTaken
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/outbox"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+)
+
+// querier is implemented by both pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// insertOrder creates the order in PENDING status together with its goods
+// and puts order_created_v1 into the outbox. When the idempotency key is given
+// it is stored in the same transaction.
+func (s Server) insertOrder(ctx context.Context, orderData model.OrderData, key idempotencyKey) (orderID int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "insertOrder")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `INSERT INTO orders (user_id, status_id, created_at) VALUES ($1, $2, NOW()) RETURNING id, created_at`, orderData.UserID, int64(saga.Pending)).Scan(&orderID, &createdAt)
+	if err != nil {
+		return 0, err
+	}
+	err = saga.Start(ctx, tx, orderID, saga.Event{Name: saga.EventOrderCreated})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, goodsID := range orderData.GoodsIds {
+		_, err = tx.Exec(ctx, `INSERT INTO order_goods (order_id, goods_id) VALUES ($1, $2)`, orderID, goodsID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if key.Key != "" {
+		err = s.storeIdempotencyKey(ctx, tx, key, orderID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	msg, err := saga.OrderCreatedMessage(orderID, orderData.GoodsIds, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	err = outbox.Enqueue(ctx, tx, msg)
+	if err != nil {
+		return 0, err
+	}
+
+	return orderID, tx.Commit(ctx)
+}
+
+// cancelOrder moves the order to CANCELLED status and puts order_cancelled_v1 into the outbox.
+// It returns saga.IllegalTransitionError for orders in a final state.
+func (s Server) cancelOrder(ctx context.Context, orderID int64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "cancelOrder")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = saga.Transition(ctx, tx, orderID, saga.Cancelled, saga.Event{Name: saga.EventOrderCancelled})
+	if err != nil {
+		return err
+	}
+
+	goods, err := selectOrderGoods(ctx, tx, []int64{orderID})
+	if err != nil {
+		return err
+	}
+
+	msg, err := saga.OrderCancelledMessage(orderID, goods[orderID])
+	if err != nil {
+		return err
+	}
+	err = outbox.Enqueue(ctx, tx, msg)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s Server) selectOrder(ctx context.Context, orderID int64) (model.OrderInfo, error) {
+	order := model.OrderInfo{}
+	err := s.db.QueryRow(ctx, `
+		SELECT o.id, o.user_id, s.name, o.created_at, o.updated_at
+		FROM orders o
+		JOIN statuses s ON s.id = o.status_id
+		WHERE o.id = $1`, orderID).Scan(&order.ID, &order.UserID, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return order, err
+	}
+
+	goods, err := selectOrderGoods(ctx, s.db, []int64{orderID})
+	if err != nil {
+		return order, err
+	}
+	order.GoodsIds = goods[orderID]
+
+	return order, nil
+}
+
+// listOrders returns one page of orders ordered by id descending (newest first).
+// One extra row is fetched to find out whether the next page exists.
+func (s Server) listOrders(ctx context.Context, filter model.OrderFilter) (model.OrderList, error) {
+	list := model.OrderList{Orders: []model.OrderInfo{}}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.UserID != 0 {
+		addCondition("o.user_id = $%d", filter.UserID)
+	}
+	if filter.StatusID != 0 {
+		addCondition("o.status_id = $%d", filter.StatusID)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		// created_at is TIMESTAMP in UTC, the offset of a non-UTC time would be dropped by Postgres.
+		addCondition("o.created_at >= $%d", filter.CreatedFrom.UTC())
+	}
+	if !filter.CreatedTo.IsZero() {
+		addCondition("o.created_at < $%d", filter.CreatedTo.UTC())
+	}
+	if filter.AfterID != 0 {
+		addCondition("o.id < $%d", filter.AfterID)
+	}
+
+	query := `
+		SELECT o.id, o.user_id, s.name, o.created_at, o.updated_at
+		FROM orders o
+		JOIN statuses s ON s.id = o.status_id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY o.id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := model.OrderInfo{}
+		err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.CreatedAt, &order.UpdatedAt)
+		if err != nil {
+			return list, err
+		}
+		list.Orders = append(list.Orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return list, err
+	}
+
+	if len(list.Orders) > filter.Limit {
+		list.Orders = list.Orders[:filter.Limit]
+		list.NextCursor = encodeCursor(list.Orders[filter.Limit-1].ID)
+	}
+
+	orderIDs := make([]int64, 0, len(list.Orders))
+	for _, order := range list.Orders {
+		orderIDs = append(orderIDs, order.ID)
+	}
+	goods, err := selectOrderGoods(ctx, s.db, orderIDs)
+	if err != nil {
+		return list, err
+	}
+	for i := range list.Orders {
+		list.Orders[i].GoodsIds = goods[list.Orders[i].ID]
+	}
+
+	return list, nil
+}
+
+// selectOrderGoods returns goods of every given order. Orders without goods get an empty slice.
+func selectOrderGoods(ctx context.Context, q querier, orderIDs []int64) (map[int64][]int64, error) {
+	goods := make(map[int64][]int64, len(orderIDs))
+	for _, orderID := range orderIDs {
+		goods[orderID] = []int64{}
+	}
+	if len(orderIDs) == 0 {
+		return goods, nil
+	}
+
+	rows, err := q.Query(ctx, `SELECT order_id, goods_id FROM order_goods WHERE order_id = ANY($1) ORDER BY id`, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID, goodsID int64
+		if err := rows.Scan(&orderID, &goodsID); err != nil {
+			return nil, err
+		}
+		goods[orderID] = append(goods[orderID], goodsID)
+	}
+
+	return goods, rows.Err()
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
+)
+
+const defaultMaxRequestBodyBytes = 1 << 20
+
+// readBody reads the request body and fails with model.Error if it is longer than limit.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, model.Error{Code: model.ErrCodeInvalidBody, Message: "request body hasn't been read"}
+	}
+	if int64(len(body)) > limit {
+		return nil, model.Error{Code: model.ErrCodeBodyTooLarge, Message: fmt.Sprintf("request body must not exceed %d bytes", limit)}
+	}
+
+	return body, nil
+}
+
+// decodeStrict unmarshals the JSON body rejecting unknown fields.
+func decodeStrict(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err == nil {
+		return nil
+	}
+
+	// encoding/json has no typed error for unknown fields, only the message `json: unknown field "name"`.
+	if field := strings.TrimPrefix(err.Error(), `json: unknown field `); field != err.Error() {
+		return model.Error{Code: model.ErrCodeUnknownField, Message: "unknown field " + field, Field: strings.Trim(field, `"`)}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return model.Error{Code: model.ErrCodeInvalidValue, Message: fmt.Sprintf("%s must be %s", typeErr.Field, typeErr.Type), Field: typeErr.Field}
+	}
+
+	return model.Error{Code: model.ErrCodeInvalidJSON, Message: err.Error()}
+}
@@ -2,166 +2,233 @@ package transport
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/kybuk_oo/example_go_metrics/orders/pkg/monitoring"
 	"github.com/prometheus/client_golang/prometheus"
-	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/kybuk_oo/example_go_metrics/orders/pkg/model"
-	"github.com/rs/zerolog/log"
+	"github.com/kybuk_oo/example_go_metrics/orders/pkg/saga"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/health"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
-	router        *mux.Router
-	db            *pgxpool.Pool
-	kafkaProducer sarama.SyncProducer
-	metrics       monitoring.Metrics
+	router     *mux.Router
+	httpServer *http.Server
+	db         *pgxpool.Pool
+	metrics    monitoring.Metrics
+
+	idempotencyKeyTTL time.Duration
+	maxBodyBytes      int64
 }
 
-func NewServer(db *pgxpool.Pool, kafkaProducer sarama.SyncProducer, metrics monitoring.Metrics) Server {
+func NewServer(db *pgxpool.Pool, metrics monitoring.Metrics, checker health.Checker) Server {
 	s := Server{}
-	s.kafkaProducer = kafkaProducer
 	s.db = db
 	s.metrics = metrics
+	s.idempotencyKeyTTL = config.Duration("IDEMPOTENCY_KEY_TTL", defaultIdempotencyKeyTTL)
+	s.maxBodyBytes = config.Int64("MAX_REQUEST_BODY_BYTES", defaultMaxRequestBodyBytes, 1)
 	s.router = mux.NewRouter()
+	s.router.Use(requestid.Middleware, tracing.Middleware, metrics.Middleware)
+
+	s.router.HandleFunc("/v1/orders", s.CreateOrderV1).Methods(http.MethodPost).Name("CreateOrderV1")
+	s.router.HandleFunc("/v1/orders", s.ListOrdersV1).Methods(http.MethodGet).Name("ListOrdersV1")
+	s.router.HandleFunc("/v1/orders/{id:[0-9]+}", s.GetOrderV1).Methods(http.MethodGet).Name("GetOrderV1")
+	s.router.HandleFunc("/v1/orders/{id:[0-9]+}/cancel", s.CancelOrderV1).Methods(http.MethodPost).Name("CancelOrderV1")
+	s.router.HandleFunc("/healthz", health.Healthz).Methods(http.MethodGet).Name("Healthz")
+	s.router.HandleFunc("/readyz", checker.Readyz).Methods(http.MethodGet).Name("Readyz")
 
-	s.router.HandleFunc("/v1/orders", s.CreateOrderV1).Methods(http.MethodPost)
+	s.httpServer = &http.Server{Addr: ":" + os.Getenv("HTTP_BIND"), Handler: s.router}
 
 	return s
 }
 
+// Start serves HTTP until Shutdown is called, then it returns http.ErrServerClosed.
 func (s Server) Start() error {
-	return http.ListenAndServe(":"+os.Getenv("HTTP_BIND"), s.router)
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops accepting connections and waits for the in-flight requests until ctx is done.
+func (s Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
+// HTTP metrics are recorded by monitoring.Metrics.Middleware, the handlers only count the outcome in request_send.
+
 func (s Server) CreateOrderV1(w http.ResponseWriter, r *http.Request) {
-	//### START Метрика количества активных вызовов создания заказа Increment
-	s.metrics.Gauge["work_order_create"].Inc()
-	//### END Метрика количества активных вызовов создания заказа Increment
-	now := time.Now()
 	sleep(200)
-	body, err := ioutil.ReadAll(r.Body)
+	orderData := model.OrderData{}
+	body, err := readBody(r, s.maxBodyBytes)
+	if err == nil {
+		err = decodeStrict(body, &orderData)
+	}
+	if err == nil {
+		err = orderData.Validate()
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Data hasn't been read.")
-		w.WriteHeader(http.StatusBadRequest)
-		//### START Метрика продолжительности вызова создания заказа Histogram
-		s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusBadRequest)}).Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Histogram
-		//### START Метрика продолжительности вызова создания заказа Summary
-		s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Summary
-		//### START Метрика количества результатов создания заказа
-		s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_failed_bad_request"}).Inc()
-		//### END Метрика количества результатов создания заказа
-		//### START Метрика количества активных вызовов создания заказа Decrement
-		s.metrics.Gauge["work_order_create"].Dec()
-		//### END Метрика количества активных вызовов создания заказа Decrement
+		requestid.Logger(r.Context()).Error().Err(err).Msg("Data hasn't been parsed.")
+		s.rejectInvalidRequest(w, "CreateOrderV1", err)
 		return
 	}
 
-	orderData := model.OrderData{}
-	err = json.Unmarshal(body, &orderData)
-	if err != nil {
-		log.Error().Err(err).Msg("Data hasn't been parsed.")
-		w.WriteHeader(http.StatusBadRequest)
-		//### START Метрика продолжительности вызова создания заказа Histogram
-		s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusBadRequest)}).Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Histogram
-		//### START Метрика продолжительности вызова создания заказа Summary
-		s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Summary
-		//### START Метрика количества результатов создания заказа
-		s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_failed_bad_request"}).Inc()
-		//### END Метрика количества результатов создания заказа
-		//### START Метрика количества активных вызовов создания заказа Decrement
-		s.metrics.Gauge["work_order_create"].Dec()
-		//### END Метрика количества активных вызовов создания заказа Decrement
+	// The order is created even if the client has gone away.
+	ctx := detach(r.Context())
+
+	key := newIdempotencyKey(r.Header.Get(idempotencyKeyHeader), body)
+	if key.Key != "" && s.replayCreateOrder(ctx, w, key, orderData) {
 		return
 	}
 
-	var orderID int64
-	err = s.db.QueryRow(context.Background(), `INSERT INTO orders (user_id, status_id, created_at) VALUES ($1, 1, NOW()) RETURNING id`, orderData.UserID).Scan(&orderID)
+	orderID, err := s.insertOrder(ctx, orderData, key)
+	if errors.Is(err, errIdempotencyKeyTaken) && s.replayCreateOrder(ctx, w, key, orderData) {
+		return
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Order hasn't been created.")
-		w.WriteHeader(http.StatusInternalServerError)
-		//### START Метрика продолжительности вызова создания заказа Histogram
-		s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusInternalServerError)}).Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Histogram
-		//### START Метрика продолжительности вызова создания заказа Summary
-		s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Summary
-		//### START Метрика количества результатов создания заказа
-		s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_failed_server"}).Inc()
-		//### END Метрика количества результатов создания заказа
-		//### START Метрика количества активных вызовов создания заказа Decrement
-		s.metrics.Gauge["work_order_create"].Dec()
-		//### END Метрика количества активных вызовов создания заказа Decrement
+		requestid.Logger(ctx).Error().Err(err).Msg("Order hasn't been created.")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		s.countRequest("request_order_failed_server")
 		return
 	}
 
-	msg := model.CreatedOrderMsg{Data: model.Order{
+	writeCreatedOrder(w, orderID, orderData.GoodsIds)
+	s.countRequest("request_order_success")
+}
+
+// detach returns a context that isn't cancelled with the request, but keeps its request ID,
+// span and logger.
+func detach(ctx context.Context) context.Context {
+	detached := requestid.NewContext(context.Background(), requestid.FromContext(ctx))
+	detached = trace.ContextWithSpan(detached, trace.SpanFromContext(ctx))
+
+	return zerolog.Ctx(ctx).WithContext(detached)
+}
+
+// replayCreateOrder repeats the original CreateOrderV1 response for a request with a known idempotency key.
+// The request body matches the original one, so the response is rebuilt from orderData.
+// It returns false when the key is unknown and the order has to be created.
+func (s Server) replayCreateOrder(ctx context.Context, w http.ResponseWriter, key idempotencyKey, orderData model.OrderData) bool {
+	orderID, found, err := s.findIdempotentOrder(ctx, key)
+	switch {
+	case errors.Is(err, errIdempotencyKeyReused):
+		writeError(w, http.StatusConflict, model.Error{Code: model.ErrCodeConflict, Message: err.Error()})
+		s.countRequest("request_order_failed_conflict")
+	case err != nil:
+		requestid.Logger(ctx).Error().Err(err).Msg("Idempotency key hasn't been checked.")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		s.countRequest("request_order_failed_server")
+	case found:
+		s.metrics.Counter["request_replayed"].With(prometheus.Labels{"method": "CreateOrderV1"}).Inc()
+		writeCreatedOrder(w, orderID, orderData.GoodsIds)
+		s.countRequest("request_order_replayed")
+	default:
+		return false
+	}
+
+	return true
+}
+
+// writeCreatedOrder responds with 201 Created, the Location of the order and the order itself.
+func writeCreatedOrder(w http.ResponseWriter, orderID int64, goodsIds []int64) {
+	w.Header().Set("Location", "/v1/orders/"+strconv.FormatInt(orderID, 10))
+	writeJSON(w, http.StatusCreated, model.CreatedOrder{
 		ID:       orderID,
-		GoodsIds: orderData.GoodsIds,
-	}}
-	msgStr, err := json.Marshal(msg)
+		Status:   saga.Pending.String(),
+		GoodsIds: goodsIds,
+	})
+}
+
+func (s Server) GetOrderV1(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		log.Error().Err(err).Msg("Message hasn't been marshaled.")
-		w.WriteHeader(http.StatusInternalServerError)
-		//### START Метрика продолжительности вызова создания заказа Histogram
-		s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusInternalServerError)}).Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Histogram
-		//### START Метрика продолжительности вызова создания заказа Summary
-		s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Summary
-		//### START Метрика количества результатов создания заказа
-		s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_failed_server"}).Inc()
-		//### END Метрика количества результатов создания заказа
-		//### START Метрика количества активных вызовов создания заказа Decrement
-		s.metrics.Gauge["work_order_create"].Dec()
-		//### END Метрика количества активных вызовов создания заказа Decrement
+		s.rejectInvalidRequest(w, "GetOrderV1", errInvalidID)
 		return
 	}
 
-	producerMsg := &sarama.ProducerMessage{Topic: os.Getenv("ORDER_CREATED_TOPIC"), Value: sarama.StringEncoder(msgStr)}
-	_, _, err = s.kafkaProducer.SendMessage(producerMsg)
+	order, err := s.selectOrder(r.Context(), orderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errOrderNotFound)
+		s.countRequest("request_order_get_not_found")
+		return
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Message hasn't been sent.")
-		w.WriteHeader(http.StatusInternalServerError)
-		//### START Метрика продолжительности вызова создания заказа Histogram
-		s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusInternalServerError)}).Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Histogram
-		//### START Метрика продолжительности вызова создания заказа Summary
-		s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-		//### END Метрика продолжительности вызова создания заказа Summary
-		//### START Метрика количества результатов создания заказа
-		s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_failed_server"}).Inc()
-		//### END Метрика количества результатов создания заказа
-		//### START Метрика количества активных вызовов создания заказа Decrement
-		s.metrics.Gauge["work_order_create"].Dec()
-		//### END Метрика количества активных вызовов создания заказа Decrement
+		requestid.Logger(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Order hasn't been selected.")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		s.countRequest("request_order_get_failed_server")
 		return
 	}
 
-	//### START Метрика количества результатов создания заказа
-	s.metrics.Counter["request_send"].With(prometheus.Labels{"type": "request_order_success"}).Inc()
-	//### END Метрика количества результатов создания заказа
-	//### START Метрика продолжительности вызова создания заказа Histogram
-	s.metrics.Histogram["request_processing_time_histogram_ms"].With(prometheus.Labels{"method": "CreateOrderV1", "status": strconv.Itoa(http.StatusOK)}).Observe(time.Since(now).Seconds())
-	//### END Метрика продолжительности вызова создания заказа Histogram
-	//### START Метрика продолжительности вызова создания заказа Summary
-	s.metrics.Summary["request_processing_time_summary_ms"].Observe(time.Since(now).Seconds())
-	//### END Метрика продолжительности вызова создания заказа Summary
-	//### START Метрика количества активных вызовов создания заказа Decrement
-	s.metrics.Gauge["work_order_create"].Dec()
-	//### END Метрика количества активных вызовов создания заказа Decrement
+	writeJSON(w, http.StatusOK, order)
+	s.countRequest("request_order_get_success")
+}
+
+func (s Server) ListOrdersV1(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		s.rejectInvalidRequest(w, "ListOrdersV1", err)
+		return
+	}
+
+	list, err := s.listOrders(r.Context(), filter)
+	if err != nil {
+		requestid.Logger(r.Context()).Error().Err(err).Msg("Orders haven't been selected.")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		s.countRequest("request_order_list_failed_server")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+	s.countRequest("request_order_list_success")
+}
+
+func (s Server) CancelOrderV1(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.rejectInvalidRequest(w, "CancelOrderV1", errInvalidID)
+		return
+	}
+
+	err = s.cancelOrder(r.Context(), orderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errOrderNotFound)
+		s.countRequest("request_order_cancel_not_found")
+		return
+	}
+	var illegalTransition saga.IllegalTransitionError
+	if errors.As(err, &illegalTransition) {
+		writeError(w, http.StatusConflict, model.Error{
+			Code:    model.ErrCodeConflict,
+			Message: fmt.Sprintf("order is %s and can't be cancelled", illegalTransition.From),
+		})
+		s.countRequest("request_order_cancel_conflict")
+		return
+	}
+	if err != nil {
+		requestid.Logger(r.Context()).Error().Err(err).Int64("order_id", orderID).Msg("Order hasn't been cancelled.")
+		writeError(w, http.StatusInternalServerError, errInternal)
+		s.countRequest("request_order_cancel_failed_server")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	s.countRequest("request_order_cancel_success")
+}
+
+// countRequest counts the result of the request in request_send.
+func (s Server) countRequest(resultType string) {
+	s.metrics.Counter["request_send"].With(prometheus.Labels{"type": resultType}).Inc()
 }
 
 func sleep(ms int) {
@@ -0,0 +1,91 @@
+// Package config reads the settings from the environment, the invalid values fall back to the defaults.
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// String returns the variable or defaultValue if it is empty.
+func String(name, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+// Duration reads a positive Go duration, e.g. "30s".
+func Duration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Error().Err(err).Str("value", value).Msgf("Invalid %s, default is used.", name)
+		return defaultValue
+	}
+
+	return duration
+}
+
+// Durations reads a comma-separated list of positive Go durations, e.g. "30s,5m".
+func Durations(name string, defaultValue []time.Duration) []time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	var durations []time.Duration
+	for _, item := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(item))
+		if err != nil || duration <= 0 {
+			log.Error().Err(err).Str("value", value).Msgf("Invalid %s, default is used.", name)
+			return defaultValue
+		}
+		durations = append(durations, duration)
+	}
+
+	return durations
+}
+
+// Int reads an integer not less than min.
+func Int(name string, defaultValue, min int) int {
+	return int(Int64(name, int64(defaultValue), int64(min)))
+}
+
+// Int64 reads an integer not less than min.
+func Int64(name string, defaultValue, min int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < min {
+		log.Error().Err(err).Str("value", value).Msgf("Invalid %s, default is used.", name)
+		return defaultValue
+	}
+
+	return number
+}
+
+// Bool reads a boolean, e.g. "true" or "1".
+func Bool(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Error().Err(err).Str("value", value).Msgf("Invalid %s, default is used.", name)
+		return defaultValue
+	}
+
+	return b
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testVariable = "CONFIG_TEST_VALUE"
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: time.Minute},
+		{value: "30s", want: 30 * time.Second},
+		{value: "0s", want: time.Minute},
+		{value: "-1s", want: time.Minute},
+		{value: "30", want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(testVariable, tt.value)
+			if got := Duration(testVariable, time.Minute); got != tt.want {
+				t.Fatalf("Duration = %s, %s is expected", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurations(t *testing.T) {
+	defaultValue := []time.Duration{time.Second}
+	tests := []struct {
+		value string
+		want  []time.Duration
+	}{
+		{value: "", want: defaultValue},
+		{value: "30s", want: []time.Duration{30 * time.Second}},
+		{value: "30s, 5m", want: []time.Duration{30 * time.Second, 5 * time.Minute}},
+		{value: "30s,", want: defaultValue},
+		{value: "30s,-5m", want: defaultValue},
+		{value: "30s,five", want: defaultValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(testVariable, tt.value)
+			if got := Durations(testVariable, defaultValue); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Durations = %v, %v is expected", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{value: "", want: 5},
+		{value: "1", want: 1},
+		{value: "100", want: 100},
+		{value: "0", want: 5},
+		{value: "-3", want: 5},
+		{value: "1.5", want: 5},
+		{value: "ten", want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(testVariable, tt.value)
+			if got := Int(testVariable, 5, 1); got != tt.want {
+				t.Fatalf("Int = %d, %d is expected", got, tt.want)
+			}
+			if got := Int64(testVariable, 5, 1); got != int64(tt.want) {
+				t.Fatalf("Int64 = %d, %d is expected", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBool(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: true},
+		{value: "false", want: false},
+		{value: "0", want: false},
+		{value: "TRUE", want: true},
+		{value: "no", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(testVariable, tt.value)
+			if got := Bool(testVariable, true); got != tt.want {
+				t.Fatalf("Bool = %t, %t is expected", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	t.Setenv(testVariable, "")
+	if got := String(testVariable, "default"); got != "default" {
+		t.Fatalf("String = %q, default is expected", got)
+	}
+	t.Setenv(testVariable, "value")
+	if got := String(testVariable, "default"); got != "value" {
+		t.Fatalf("String = %q, value is expected", got)
+	}
+}
@@ -0,0 +1,87 @@
+// Package events declares the versioned event contracts validated by JSON Schema.
+package events
+
+import (
+	"strconv"
+	"time"
+)
+
+// Names of the events regardless of their versions.
+const (
+	OrderCreated   = "order_created"
+	OrderCancelled = "order_cancelled"
+	GoodsCreated   = "goods_created"
+	GoodsRejected  = "goods_rejected"
+)
+
+// Schemas of the events, they match the names of the topics the events are published to.
+const (
+	SchemaOrderCreatedV1   = "order_created_v1"
+	SchemaOrderCreatedV2   = "order_created_v2"
+	SchemaOrderCancelledV1 = "order_cancelled_v1"
+	SchemaGoodsCreatedV1   = "goods_created_v1"
+	SchemaGoodsRejectedV1  = "goods_rejected_v1"
+)
+
+// ID identifies the event of the order in all its copies, e.g. order_created:42.
+func ID(name string, orderID int64) string {
+	return name + ":" + strconv.FormatInt(orderID, 10)
+}
+
+type Order struct {
+	ID       int64   `json:"id"`
+	GoodsIds []int64 `json:"goods_ids"`
+}
+
+type Goods struct {
+	OrderID int64 `json:"order_id"`
+}
+
+type OrderCreatedV1 struct {
+	Data Order `json:"data"`
+}
+
+// OrderCreatedV2 is flat and carries the time the order was created at.
+type OrderCreatedV2 struct {
+	OrderID   int64     `json:"order_id"`
+	GoodsIds  []int64   `json:"goods_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderCancelledV1 struct {
+	Data Order `json:"data"`
+}
+
+type GoodsCreatedV1 struct {
+	Data Goods `json:"data"`
+}
+
+type GoodsRejectedV1 struct {
+	Data Goods `json:"data"`
+}
+
+// UpcastOrderCreatedV1 converts v1 into v2 created at createdAt.
+func UpcastOrderCreatedV1(event OrderCreatedV1, createdAt time.Time) OrderCreatedV2 {
+	return OrderCreatedV2{
+		OrderID:   event.Data.ID,
+		GoodsIds:  event.Data.GoodsIds,
+		CreatedAt: createdAt,
+	}
+}
+
+// DecodeOrderCreated decodes order_created of any supported version into the latest one.
+func DecodeOrderCreated(schema string, data []byte, timestamp time.Time) (OrderCreatedV2, error) {
+	switch schema {
+	case SchemaOrderCreatedV1:
+		event := OrderCreatedV1{}
+		err := Unmarshal(schema, data, &event)
+		if err != nil {
+			return OrderCreatedV2{}, err
+		}
+		return UpcastOrderCreatedV1(event, timestamp), nil
+	default:
+		event := OrderCreatedV2{}
+		err := Unmarshal(schema, data, &event)
+		return event, err
+	}
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var createdAt = time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// contracts has an example of every event, the test fails when a schema has no example
+// or the type doesn't match its schema anymore.
+var contracts = map[string]interface{}{
+	SchemaOrderCreatedV1:   OrderCreatedV1{Data: Order{ID: 1, GoodsIds: []int64{10, 11}}},
+	SchemaOrderCreatedV2:   OrderCreatedV2{OrderID: 1, GoodsIds: []int64{10, 11}, CreatedAt: createdAt},
+	SchemaOrderCancelledV1: OrderCancelledV1{Data: Order{ID: 1, GoodsIds: []int64{10, 11}}},
+	SchemaGoodsCreatedV1:   GoodsCreatedV1{Data: Goods{OrderID: 1}},
+	SchemaGoodsRejectedV1:  GoodsRejectedV1{Data: Goods{OrderID: 1}},
+}
+
+func TestEventsMatchSchemas(t *testing.T) {
+	for schema := range schemas {
+		if _, ok := contracts[schema]; !ok {
+			t.Errorf("schema %s has no example event", schema)
+		}
+	}
+
+	for schema, event := range contracts {
+		t.Run(schema, func(t *testing.T) {
+			data, err := Marshal(schema, event)
+			if err != nil {
+				t.Fatalf("example doesn't match the schema: %v", err)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(event))
+			err = Unmarshal(schema, data, decoded.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Elem().Interface(), event) {
+				t.Fatalf("decoded %+v, %+v is expected", decoded.Elem().Interface(), event)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+	}{
+		{name: "missing order id", schema: SchemaOrderCreatedV1, data: `{"data":{"goods_ids":[10]}}`},
+		{name: "renamed order id", schema: SchemaOrderCreatedV1, data: `{"data":{"order_id":1,"goods_ids":[10]}}`},
+		{name: "missing goods ids", schema: SchemaOrderCreatedV1, data: `{"data":{"id":1}}`},
+		{name: "null goods ids", schema: SchemaOrderCreatedV1, data: `{"data":{"id":1,"goods_ids":null}}`},
+		{name: "v1 payload as v2", schema: SchemaOrderCreatedV2, data: `{"data":{"id":1,"goods_ids":[10]}}`},
+		{name: "missing created_at", schema: SchemaOrderCreatedV2, data: `{"order_id":1,"goods_ids":[10]}`},
+		{name: "null goods ids of cancelled", schema: SchemaOrderCancelledV1, data: `{"data":{"id":1,"goods_ids":null}}`},
+		{name: "renamed goods order id", schema: SchemaGoodsCreatedV1, data: `{"data":{"orderId":1}}`},
+		{name: "missing rejected order id", schema: SchemaGoodsRejectedV1, data: `{"data":{}}`},
+		{name: "not positive id", schema: SchemaGoodsRejectedV1, data: `{"data":{"order_id":0}}`},
+		{name: "invalid JSON", schema: SchemaGoodsCreatedV1, data: `{"data":`},
+		{name: "unknown schema", schema: "goods_created_v0", data: `{"data":{"order_id":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.schema, []byte(tt.data))
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Schema != tt.schema {
+				t.Fatalf("error = %v, ValidationError of %s is expected", err, tt.schema)
+			}
+		})
+	}
+}
+
+func TestMarshalRejectsNilGoodsIds(t *testing.T) {
+	events := map[string]interface{}{
+		SchemaOrderCreatedV1:   OrderCreatedV1{Data: Order{ID: 1}},
+		SchemaOrderCreatedV2:   OrderCreatedV2{OrderID: 1, CreatedAt: createdAt},
+		SchemaOrderCancelledV1: OrderCancelledV1{Data: Order{ID: 1}},
+	}
+
+	for schema, event := range events {
+		t.Run(schema, func(t *testing.T) {
+			_, err := Marshal(schema, event)
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("error = %v, nil goods_ids has to be rejected", err)
+			}
+		})
+	}
+}
+
+func TestUpcastOrderCreatedV1RoundTrip(t *testing.T) {
+	v1 := OrderCreatedV1{Data: Order{ID: 1, GoodsIds: []int64{10, 11}}}
+	want := OrderCreatedV2{OrderID: 1, GoodsIds: []int64{10, 11}, CreatedAt: createdAt}
+
+	if got := UpcastOrderCreatedV1(v1, createdAt); !reflect.DeepEqual(got, want) {
+		t.Fatalf("upcast %+v, %+v is expected", got, want)
+	}
+
+	data, err := Marshal(SchemaOrderCreatedV1, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeOrderCreated(SchemaOrderCreatedV1, data, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded v1 as %+v, %+v is expected", got, want)
+	}
+
+	data, err = Marshal(SchemaOrderCreatedV2, got)
+	if err != nil {
+		t.Fatalf("upcast event doesn't match v2: %v", err)
+	}
+	got, err = DecodeOrderCreated(SchemaOrderCreatedV2, data, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.OrderID != want.OrderID || !reflect.DeepEqual(got.GoodsIds, want.GoodsIds) {
+		t.Fatalf("decoded v2 as %+v, %+v is expected", got, want)
+	}
+}
+
+func TestID(t *testing.T) {
+	if id := ID(OrderCreated, 42); id != "order_created:42" {
+		t.Fatalf("ID = %s, order_created:42 is expected", id)
+	}
+}
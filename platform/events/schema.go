@@ -0,0 +1,103 @@
+package events
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schemas are compiled at start, a broken schema panics.
+var schemas = compileSchemas()
+
+// ValidationError means the payload doesn't match the schema of the event.
+type ValidationError struct {
+	Schema string
+	Err    error
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Schema, e.Err)
+}
+
+func (e ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Marshal encodes the event and validates the payload against the schema.
+func Marshal(schema string, event interface{}) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	err = Validate(schema, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Unmarshal validates the payload against the schema and decodes it into event.
+func Unmarshal(schema string, data []byte, event interface{}) error {
+	err := Validate(schema, data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, event)
+}
+
+// Validate checks the payload against the schema.
+func Validate(schema string, data []byte) error {
+	compiled, ok := schemas[schema]
+	if !ok {
+		return ValidationError{Schema: schema, Err: errors.New("unknown schema")}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return ValidationError{Schema: schema, Err: err}
+	}
+	err = compiled.Validate(value)
+	if err != nil {
+		return ValidationError{Schema: schema, Err: err}
+	}
+
+	return nil
+}
+
+func compileSchemas() map[string]*jsonschema.Schema {
+	files, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	compiled := make(map[string]*jsonschema.Schema, len(files))
+	for _, file := range files {
+		data, err := schemaFiles.ReadFile(path.Join("schemas", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		err = compiler.AddResource(file.Name(), bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+		compiled[strings.TrimSuffix(file.Name(), ".json")] = compiler.MustCompile(file.Name())
+	}
+
+	return compiled
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	checkTimeout = 3 * time.Second
+)
+
+// Check returns an error when the dependency isn't available.
+type Check func(ctx context.Context) error
+
+// Checker runs the readiness checks and sets the dependency_up gauge.
+type Checker struct {
+	checks map[string]Check
+	gauge  *prometheus.GaugeVec
+}
+
+type Result struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]Result `json:"dependencies"`
+}
+
+// AddMetrics adds the dependency_up gauge used by the Checker.
+func AddMetrics(metrics prom.Metrics, namespace string) {
+	/*
+		# HELP dependency_up Доступность зависимости сервиса (1 - доступна, 0 - нет)
+		# TYPE dependency_up gauge
+		dependency_up{dependency="postgres"} 1
+	*/
+	dependencyUp := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "dependency_up",
+			Help:      "Доступность зависимости сервиса (1 - доступна, 0 - нет)",
+		}, []string{"dependency"})
+	metrics.GaugeVec["dependency_up"] = dependencyUp
+}
+
+func NewChecker(gauge *prometheus.GaugeVec) Checker {
+	return Checker{checks: make(map[string]Check), gauge: gauge}
+}
+
+func (c Checker) Add(dependency string, check Check) {
+	c.checks[dependency] = check
+}
+
+// Run executes all checks concurrently, each one is limited by checkTimeout.
+func (c Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusUp, Dependencies: make(map[string]Result, len(c.checks))}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for dependency, check := range c.checks {
+		wg.Add(1)
+		go func(dependency string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			result := Result{Status: StatusUp}
+			up := 1.0
+			if err := check(checkCtx); err != nil {
+				result = Result{Status: StatusDown, Error: err.Error()}
+				up = 0
+			}
+			c.gauge.With(prometheus.Labels{"dependency": dependency}).Set(up)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[dependency] = result
+			if result.Status == StatusDown {
+				report.Status = StatusDown
+			}
+		}(dependency, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Watch runs the checks every interval until ctx is done.
+func (c Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantReport string
+	}{
+		{name: "up", wantStatus: http.StatusOK, wantReport: StatusUp},
+		{name: "down", err: errors.New("connection refused"), wantStatus: http.StatusServiceUnavailable, wantReport: StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "dependency_up"}, []string{"dependency"}))
+			checker.Add("postgres", func(ctx context.Context) error { return tt.err })
+			checker.Add("kafka", func(ctx context.Context) error { return nil })
+
+			w := httptest.NewRecorder()
+			checker.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var report Report
+			err := json.Unmarshal(w.Body.Bytes(), &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus || report.Status != tt.wantReport || report.Dependencies["kafka"].Status != StatusUp {
+				t.Fatalf("response %d %+v, %d with status %s is expected", w.Code, report, tt.wantStatus, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("response %d %s, 200 with JSON is expected", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,62 @@
+// Package inbox records the consumed events to skip their duplicates.
+package inbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/kybuk_oo/example_go_metrics/platform/retention"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultRetention = 7 * 24 * time.Hour
+
+type Inbox struct {
+	metrics prom.Metrics
+}
+
+func New(metrics prom.Metrics) Inbox {
+	return Inbox{metrics: metrics}
+}
+
+// AddMetrics adds the messages_duplicated counter used by the Inbox.
+func AddMetrics(metrics prom.Metrics, namespace string) {
+	/*
+		# HELP messages_duplicated Количество повторно полученных и пропущенных сообщений
+		# TYPE messages_duplicated counter
+		messages_duplicated{topic="order_created_v1"} 1
+	*/
+	messagesDuplicated := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_duplicated",
+		Help:      "Количество повторно полученных и пропущенных сообщений",
+	}, []string{"topic"})
+	metrics.Counter["messages_duplicated"] = messagesDuplicated
+}
+
+// Mark records the event in tx and returns false if it has already been processed.
+func (i Inbox) Mark(ctx context.Context, tx pgx.Tx, msg *sarama.ConsumerMessage) (bool, error) {
+	topic, _, _ := kafka.Source(msg)
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO inbox (event_id, topic, processed_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (event_id) DO NOTHING`, kafka.EventID(msg), topic)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		i.metrics.Counter["messages_duplicated"].With(prometheus.Labels{"topic": topic}).Inc()
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// RetentionPolicy deletes the records older than INBOX_RETENTION.
+func RetentionPolicy() retention.Policy {
+	return retention.Policy{Table: "inbox", Column: "processed_at", TTL: config.Duration("INBOX_RETENTION", defaultRetention)}
+}
@@ -0,0 +1,209 @@
+package kafka
+
+import (
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+var defaultVersion = sarama.V2_3_0_0
+
+// Partitioners accepted in KAFKA_PARTITIONER.
+const (
+	PartitionerHash       = "hash"
+	PartitionerMurmur2    = "murmur2"
+	PartitionerRandom     = "random"
+	PartitionerRoundRobin = "roundrobin"
+)
+
+// sha512Hash is used for SCRAM-SHA-512, the scram package provides only SHA-1 and SHA-256.
+var sha512Hash scram.HashGeneratorFcn = sha512.New
+
+// ClientConfig holds the connection settings shared by the producer and all the consumer groups.
+type ClientConfig struct {
+	Brokers     []string
+	Version     sarama.KafkaVersion
+	Partitioner sarama.PartitionerConstructor
+	TLS         *tls.Config
+	SASL        SASLConfig
+}
+
+// SASLConfig is used when Mechanism isn't empty.
+type SASLConfig struct {
+	Mechanism string
+	User      string
+	Password  string
+}
+
+// LoadClientConfig reads the KAFKA_* connection settings, an invalid value is an error.
+func LoadClientConfig() (ClientConfig, error) {
+	cfg := ClientConfig{Version: defaultVersion, Partitioner: sarama.NewHashPartitioner}
+
+	for _, broker := range strings.Split(os.Getenv("KAFKA_ADDR"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			cfg.Brokers = append(cfg.Brokers, broker)
+		}
+	}
+	if len(cfg.Brokers) == 0 {
+		return cfg, errors.New("KAFKA_ADDR is empty")
+	}
+
+	if value := os.Getenv("KAFKA_VERSION"); value != "" {
+		version, err := sarama.ParseKafkaVersion(value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid KAFKA_VERSION: %w", err)
+		}
+		cfg.Version = version
+	}
+
+	switch value := os.Getenv("KAFKA_PARTITIONER"); value {
+	case "", PartitionerHash:
+	case PartitionerMurmur2:
+		cfg.Partitioner = sarama.NewReferenceHashPartitioner
+	case PartitionerRandom:
+		cfg.Partitioner = sarama.NewRandomPartitioner
+	case PartitionerRoundRobin:
+		cfg.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		return cfg, fmt.Errorf("invalid KAFKA_PARTITIONER %q: hash, murmur2, random or roundrobin is expected", value)
+	}
+
+	var err error
+	cfg.TLS, err = loadTLSConfig()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.SASL = SASLConfig{
+		Mechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+		User:      os.Getenv("KAFKA_SASL_USER"),
+		Password:  os.Getenv("KAFKA_SASL_PASSWORD"),
+	}
+	switch cfg.SASL.Mechanism {
+	case "":
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		if cfg.SASL.User == "" {
+			return cfg, fmt.Errorf("KAFKA_SASL_USER is required by %s", cfg.SASL.Mechanism)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512 is expected", cfg.SASL.Mechanism)
+	}
+
+	saramaCfg := sarama.NewConfig()
+	cfg.apply(saramaCfg)
+
+	return cfg, saramaCfg.Validate()
+}
+
+// apply sets the connection settings in the sarama config of a producer or a consumer group.
+func (c ClientConfig) apply(cfg *sarama.Config) {
+	cfg.Version = c.Version
+	if c.Partitioner != nil {
+		cfg.Producer.Partitioner = c.Partitioner
+	}
+	if c.TLS != nil {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = c.TLS
+	}
+	if c.SASL.Mechanism == "" {
+		return
+	}
+
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Mechanism = sarama.SASLMechanism(c.SASL.Mechanism)
+	cfg.Net.SASL.User = c.SASL.User
+	cfg.Net.SASL.Password = c.SASL.Password
+	switch c.SASL.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case sarama.SASLTypeSCRAMSHA512:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: sha512Hash} }
+	}
+}
+
+func loadTLSConfig() (*tls.Config, error) {
+	enabled, err := strictBool("KAFKA_TLS_ENABLED")
+	if err != nil {
+		return nil, err
+	}
+	skipVerify, err := strictBool("KAFKA_TLS_INSECURE_SKIP_VERIFY")
+	if err != nil {
+		return nil, err
+	}
+	caFile := os.Getenv("KAFKA_TLS_CA_FILE")
+	certFile := os.Getenv("KAFKA_TLS_CERT_FILE")
+	keyFile := os.Getenv("KAFKA_TLS_KEY_FILE")
+	if !enabled && !skipVerify && caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: skipVerify}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_TLS_CA_FILE: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid KAFKA_TLS_CA_FILE: no PEM certificates in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE are required together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KAFKA_TLS_CERT_FILE or KAFKA_TLS_KEY_FILE: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func strictBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// scramClient implements sarama.SCRAMClient for SCRAM-SHA-256 and SCRAM-SHA-512.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
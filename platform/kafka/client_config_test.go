@@ -0,0 +1,85 @@
+package kafka
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// clearClientEnv unsets the connection settings, so only the ones set by the test are used.
+func clearClientEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"KAFKA_ADDR", "KAFKA_VERSION", "KAFKA_PARTITIONER",
+		"KAFKA_TLS_ENABLED", "KAFKA_TLS_CA_FILE", "KAFKA_TLS_CERT_FILE", "KAFKA_TLS_KEY_FILE", "KAFKA_TLS_INSECURE_SKIP_VERIFY",
+		"KAFKA_SASL_MECHANISM", "KAFKA_SASL_USER", "KAFKA_SASL_PASSWORD",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadClientConfig(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv("KAFKA_ADDR", " kafka-1:9092, kafka-2:9092,")
+	t.Setenv("KAFKA_VERSION", "2.8.1")
+	t.Setenv("KAFKA_PARTITIONER", PartitionerMurmur2)
+	t.Setenv("KAFKA_TLS_ENABLED", "true")
+	t.Setenv("KAFKA_SASL_MECHANISM", sarama.SASLTypeSCRAMSHA512)
+	t.Setenv("KAFKA_SASL_USER", "orders")
+	t.Setenv("KAFKA_SASL_PASSWORD", "secret")
+
+	cfg, err := LoadClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Brokers, []string{"kafka-1:9092", "kafka-2:9092"}) {
+		t.Fatalf("brokers %v", cfg.Brokers)
+	}
+	if cfg.Version != sarama.V2_8_1_0 || cfg.TLS == nil {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	saramaCfg := sarama.NewConfig()
+	cfg.apply(saramaCfg)
+	if !saramaCfg.Net.TLS.Enable || !saramaCfg.Net.SASL.Enable || saramaCfg.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Fatal("TLS and SCRAM aren't applied")
+	}
+	// The reference partitioner is the one of the Java client, it's the only one requiring consistency.
+	if !saramaCfg.Producer.Partitioner("topic").RequiresConsistency() {
+		t.Fatal("partitioner isn't applied")
+	}
+}
+
+func TestLoadClientConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "no brokers", env: map[string]string{"KAFKA_ADDR": " , "}, wantErr: "KAFKA_ADDR"},
+		{name: "version", env: map[string]string{"KAFKA_VERSION": "2.x"}, wantErr: "KAFKA_VERSION"},
+		{name: "partitioner", env: map[string]string{"KAFKA_PARTITIONER": "crc32"}, wantErr: "KAFKA_PARTITIONER"},
+		{name: "TLS flag", env: map[string]string{"KAFKA_TLS_ENABLED": "yes please"}, wantErr: "KAFKA_TLS_ENABLED"},
+		{name: "CA file", env: map[string]string{"KAFKA_TLS_CA_FILE": "/nonexistent/ca.pem"}, wantErr: "KAFKA_TLS_CA_FILE"},
+		{name: "cert without key", env: map[string]string{"KAFKA_TLS_CERT_FILE": "/nonexistent/cert.pem"}, wantErr: "required together"},
+		{name: "SASL mechanism", env: map[string]string{"KAFKA_SASL_MECHANISM": "GSSAPI"}, wantErr: "KAFKA_SASL_MECHANISM"},
+		{name: "SASL user", env: map[string]string{"KAFKA_SASL_MECHANISM": sarama.SASLTypePlaintext}, wantErr: "KAFKA_SASL_USER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearClientEnv(t)
+			t.Setenv("KAFKA_ADDR", "kafka:9092")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := LoadClientConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, error about %s is expected", err, tt.wantErr)
+			}
+		})
+	}
+}
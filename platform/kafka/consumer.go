@@ -0,0 +1,161 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Consumers tracks the consumer groups started by RunConsumers.
+type Consumers struct {
+	wg *sync.WaitGroup
+	// sessions holds 1 for the topics having an active consumer group session.
+	sessions map[string]*int32
+}
+
+// Check reports the topics whose consumer groups have no active session.
+func (c Consumers) Check(ctx context.Context) error {
+	var inactive []string
+	for topic, active := range c.sessions {
+		if atomic.LoadInt32(active) == 0 {
+			inactive = append(inactive, topic)
+		}
+	}
+	if len(inactive) > 0 {
+		sort.Strings(inactive)
+		return fmt.Errorf("no active consumer group session for topics: %s", strings.Join(inactive, ", "))
+	}
+
+	return nil
+}
+
+// Wait blocks until every consumer group has stopped and committed its offsets or ctx is done.
+func (c Consumers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consumer is the handler of a topic together with the settings of its consumer group.
+type Consumer struct {
+	Handler sarama.ConsumerGroupHandler
+	Config  ConsumerConfig
+}
+
+// RunConsumers consumes every topic in its own consumer group until ctx is cancelled.
+func RunConsumers(ctx context.Context, client ClientConfig, consumers map[string]Consumer) (Consumers, error) {
+	kafkaConsumerGroups, err := initAllConsumerGroups(client, consumers)
+	if err != nil {
+		return Consumers{}, err
+	}
+	running := Consumers{wg: &sync.WaitGroup{}, sessions: make(map[string]*int32, len(kafkaConsumerGroups))}
+
+	for topic, group := range kafkaConsumerGroups {
+		active := new(int32)
+		running.sessions[topic] = active
+		handler := sessionTracker{ConsumerGroupHandler: consumers[topic].Handler, active: active}
+
+		running.wg.Add(1)
+		go func(topic string, group sarama.ConsumerGroup) {
+			defer running.wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().Str("panic", "true").Msg(fmt.Sprintf("%s", r))
+				}
+			}()
+			defer func() {
+				err := group.Close()
+				if err != nil {
+					log.Error().Err(err).Str("topic", topic).Msg("consumer group hasn't been closed")
+				}
+			}()
+
+			for {
+				err := group.Consume(ctx, []string{topic}, handler)
+				if err != nil {
+					log.Error().Err(err).Msg("consumer group error")
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}(topic, group)
+	}
+
+	return running, nil
+}
+
+// sessionTracker marks the topic active between Setup and Cleanup of the consumer group session.
+type sessionTracker struct {
+	sarama.ConsumerGroupHandler
+	active *int32
+}
+
+func (st sessionTracker) Setup(session sarama.ConsumerGroupSession) error {
+	err := st.ConsumerGroupHandler.Setup(session)
+	if err == nil {
+		atomic.StoreInt32(st.active, 1)
+	}
+
+	return err
+}
+
+func (st sessionTracker) Cleanup(session sarama.ConsumerGroupSession) error {
+	atomic.StoreInt32(st.active, 0)
+
+	return st.ConsumerGroupHandler.Cleanup(session)
+}
+
+func initAllConsumerGroups(client ClientConfig, consumers map[string]Consumer) (map[string]sarama.ConsumerGroup, error) {
+	groups := make(map[string]sarama.ConsumerGroup, len(consumers))
+	for topic, consumer := range consumers {
+		group, err := initGroup(client, consumer.Config)
+		if err != nil {
+			for _, started := range groups {
+				started.Close()
+			}
+			return nil, fmt.Errorf("consumer group %s of topic %s: %w", consumer.Config.GroupID, topic, err)
+		}
+		groups[topic] = group
+	}
+
+	return groups, nil
+}
+
+func initGroup(client ClientConfig, cfg ConsumerConfig) (sarama.ConsumerGroup, error) {
+	saramaCfg := cfg.saramaConfig()
+	client.apply(saramaCfg)
+	group, err := sarama.NewConsumerGroup(client.Brokers, cfg.GroupID, saramaCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error().Str("panic", "true").Msg(fmt.Sprintf("%s", r))
+			}
+		}()
+
+		for err := range group.Errors() {
+			log.Error().Err(err).Msg("consumer group error")
+		}
+	}()
+
+	return group, nil
+}
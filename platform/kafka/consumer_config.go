@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Rebalance strategies accepted in CONSUMER_REBALANCE_STRATEGY.
+const (
+	RebalanceRange             = "range"
+	RebalanceRoundRobin        = "roundrobin"
+	RebalanceSticky            = "sticky"
+	RebalanceCooperativeSticky = "cooperative-sticky"
+)
+
+const consumerEnvPrefix = "CONSUMER_"
+
+// errCooperativeRebalance is returned for cooperative-sticky, sarama has no incremental rebalance protocol.
+var errCooperativeRebalance = errors.New("cooperative-sticky rebalance isn't supported by the sarama Kafka client, use sticky")
+
+// ConsumerConfig holds the settings of the consumer group of a topic.
+type ConsumerConfig struct {
+	GroupID           string
+	ClientID          string
+	InitialOffset     int64
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceStrategy sarama.BalanceStrategy
+}
+
+// LoadConsumerConfig reads CONSUMER_<TOPIC>_<SETTING> or CONSUMER_<SETTING>, an invalid value is an error.
+func LoadConsumerConfig(topic string) (ConsumerConfig, error) {
+	defaults := sarama.NewConfig()
+	cfg := ConsumerConfig{
+		GroupID:           topic,
+		ClientID:          defaults.ClientID,
+		InitialOffset:     defaults.Consumer.Offsets.Initial,
+		SessionTimeout:    defaults.Consumer.Group.Session.Timeout,
+		HeartbeatInterval: defaults.Consumer.Group.Heartbeat.Interval,
+		RebalanceStrategy: defaults.Consumer.Group.Rebalance.Strategy,
+	}
+	prefix := consumerEnvPrefix + envName(topic) + "_"
+	setting := func(name string) (string, string) {
+		if value := os.Getenv(prefix + name); value != "" {
+			return prefix + name, value
+		}
+		return consumerEnvPrefix + name, os.Getenv(consumerEnvPrefix + name)
+	}
+
+	if value := os.Getenv(prefix + "GROUP_ID"); value != "" {
+		cfg.GroupID = value
+	}
+	if _, value := setting("CLIENT_ID"); value != "" {
+		cfg.ClientID = value
+	}
+
+	if name, value := setting("INITIAL_OFFSET"); value != "" {
+		switch value {
+		case "oldest":
+			cfg.InitialOffset = sarama.OffsetOldest
+		case "newest":
+			cfg.InitialOffset = sarama.OffsetNewest
+		default:
+			return cfg, fmt.Errorf("invalid %s %q: oldest or newest is expected", name, value)
+		}
+	}
+
+	var err error
+	if name, value := setting("SESSION_TIMEOUT"); value != "" {
+		cfg.SessionTimeout, err = parsePositiveDuration(name, value)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if name, value := setting("HEARTBEAT_INTERVAL"); value != "" {
+		cfg.HeartbeatInterval, err = parsePositiveDuration(name, value)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	if name, value := setting("REBALANCE_STRATEGY"); value != "" {
+		switch value {
+		case RebalanceRange:
+			cfg.RebalanceStrategy = sarama.BalanceStrategyRange
+		case RebalanceRoundRobin:
+			cfg.RebalanceStrategy = sarama.BalanceStrategyRoundRobin
+		case RebalanceSticky:
+			cfg.RebalanceStrategy = sarama.BalanceStrategySticky
+		case RebalanceCooperativeSticky:
+			return cfg, fmt.Errorf("invalid %s: %w", name, errCooperativeRebalance)
+		default:
+			return cfg, fmt.Errorf("invalid %s %q: range, roundrobin or sticky is expected", name, value)
+		}
+	}
+
+	if cfg.HeartbeatInterval >= cfg.SessionTimeout {
+		return cfg, fmt.Errorf("heartbeat interval %s of topic %s has to be less than session timeout %s", cfg.HeartbeatInterval, topic, cfg.SessionTimeout)
+	}
+
+	return cfg, cfg.saramaConfig().Validate()
+}
+
+// forRetryStage returns the settings of the consumer group of the retry topic of the stage.
+func (c ConsumerConfig) forRetryStage(stage int) ConsumerConfig {
+	if stage > 0 {
+		c.GroupID = RetryTopic(c.GroupID, stage)
+	}
+
+	return c
+}
+
+func (c ConsumerConfig) saramaConfig() *sarama.Config {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = c.ClientID
+	cfg.Consumer.Return.Errors = true
+	cfg.Consumer.Offsets.Initial = c.InitialOffset
+	cfg.Consumer.Group.Session.Timeout = c.SessionTimeout
+	cfg.Consumer.Group.Heartbeat.Interval = c.HeartbeatInterval
+	cfg.Consumer.Group.Rebalance.Strategy = c.RebalanceStrategy
+
+	return cfg
+}
+
+func parsePositiveDuration(name, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: positive duration is expected", name, value)
+	}
+
+	return duration, nil
+}
+
+// envName turns the topic into a part of the variable name, e.g. order_created_v1 into ORDER_CREATED_V1.
+func envName(topic string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, topic)
+}
@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const testTopic = "order_created_v1"
+
+// clearConsumerEnv unsets the settings of the test topic and the shared ones.
+func clearConsumerEnv(t *testing.T) {
+	t.Helper()
+	for _, setting := range []string{"GROUP_ID", "CLIENT_ID", "INITIAL_OFFSET", "SESSION_TIMEOUT", "HEARTBEAT_INTERVAL", "REBALANCE_STRATEGY"} {
+		t.Setenv("CONSUMER_"+setting, "")
+		t.Setenv("CONSUMER_ORDER_CREATED_V1_"+setting, "")
+	}
+}
+
+func TestLoadConsumerConfigDefaults(t *testing.T) {
+	clearConsumerEnv(t)
+
+	cfg, err := LoadConsumerConfig(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GroupID != testTopic || cfg.InitialOffset != sarama.OffsetNewest || cfg.SessionTimeout != 10*time.Second ||
+		cfg.HeartbeatInterval != 3*time.Second || cfg.RebalanceStrategy != sarama.BalanceStrategyRange {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoadConsumerConfigPrefersTopicSettings(t *testing.T) {
+	clearConsumerEnv(t)
+	t.Setenv("CONSUMER_GROUP_ID", "shared")
+	t.Setenv("CONSUMER_CLIENT_ID", "goods")
+	t.Setenv("CONSUMER_INITIAL_OFFSET", "newest")
+	t.Setenv("CONSUMER_ORDER_CREATED_V1_INITIAL_OFFSET", "oldest")
+	t.Setenv("CONSUMER_REBALANCE_STRATEGY", "sticky")
+
+	cfg, err := LoadConsumerConfig(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GroupID != testTopic {
+		t.Fatalf("group %s, the shared CONSUMER_GROUP_ID has to be ignored", cfg.GroupID)
+	}
+	if cfg.ClientID != "goods" || cfg.InitialOffset != sarama.OffsetOldest || cfg.RebalanceStrategy != sarama.BalanceStrategySticky {
+		t.Fatalf("unexpected settings %+v", cfg)
+	}
+	if group := cfg.forRetryStage(1).GroupID; group != "order_created_v1.retry.1" {
+		t.Fatalf("retry group %s", group)
+	}
+}
+
+func TestLoadConsumerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "initial offset", env: map[string]string{"CONSUMER_INITIAL_OFFSET": "latest"}, wantErr: "CONSUMER_INITIAL_OFFSET"},
+		{name: "session timeout", env: map[string]string{"CONSUMER_ORDER_CREATED_V1_SESSION_TIMEOUT": "ten"}, wantErr: "CONSUMER_ORDER_CREATED_V1_SESSION_TIMEOUT"},
+		{name: "negative heartbeat", env: map[string]string{"CONSUMER_HEARTBEAT_INTERVAL": "-1s"}, wantErr: "CONSUMER_HEARTBEAT_INTERVAL"},
+		{name: "heartbeat not less than session", env: map[string]string{"CONSUMER_SESSION_TIMEOUT": "6s", "CONSUMER_HEARTBEAT_INTERVAL": "6s"}, wantErr: "heartbeat interval"},
+		{name: "unknown strategy", env: map[string]string{"CONSUMER_REBALANCE_STRATEGY": "random"}, wantErr: "CONSUMER_REBALANCE_STRATEGY"},
+		{name: "cooperative sticky", env: map[string]string{"CONSUMER_REBALANCE_STRATEGY": RebalanceCooperativeSticky}, wantErr: errCooperativeRebalance.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConsumerEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := LoadConsumerConfig(testTopic)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, error about %s is expected", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConsumerConfigRejectsCooperativeSticky(t *testing.T) {
+	clearConsumerEnv(t)
+	t.Setenv("CONSUMER_REBALANCE_STRATEGY", RebalanceCooperativeSticky)
+
+	_, err := LoadConsumerConfig(testTopic)
+	if !errors.Is(err, errCooperativeRebalance) {
+		t.Fatalf("error = %v, errCooperativeRebalance is expected", err)
+	}
+}
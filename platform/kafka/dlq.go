@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons of dead-lettering, they are used as the reason label and the dlq-reason header.
+const (
+	ReasonDecodeError  = "decode_error"
+	ReasonSchemaError  = "schema_error"
+	ReasonDBError      = "db_error"
+	ReasonProduceError = "produce_error"
+)
+
+// Headers added to the dead-lettered message next to the original ones.
+const (
+	HeaderDLQError           = "dlq-error"
+	HeaderDLQReason          = "dlq-reason"
+	HeaderDLQSourceTopic     = "dlq-source-topic"
+	HeaderDLQSourcePartition = "dlq-source-partition"
+	HeaderDLQSourceOffset    = "dlq-source-offset"
+	HeaderDLQAttempt         = "dlq-attempt"
+)
+
+const dlqSuffix = ".dlq"
+
+// DLQTopic returns the dead-letter topic of the topic.
+func DLQTopic(topic string) string {
+	return topic + dlqSuffix
+}
+
+// DeadLetter forwards the messages that can't be processed to <topic>.dlq.
+type DeadLetter struct {
+	producer sarama.SyncProducer
+	metrics  prom.Metrics
+}
+
+func NewDeadLetter(producer sarama.SyncProducer, metrics prom.Metrics) DeadLetter {
+	return DeadLetter{producer: producer, metrics: metrics}
+}
+
+// Send publishes msg to the dead-letter topic of its source topic.
+func (dl DeadLetter) Send(msg *sarama.ConsumerMessage, reason string, cause error, attempt int) error {
+	topic := sourceTopic(msg)
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+6)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderDLQError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderDLQReason), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQSourceTopic), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQSourcePartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQSourceOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQAttempt), Value: []byte(strconv.Itoa(attempt))},
+	)
+
+	producerMsg := &sarama.ProducerMessage{
+		Topic:   DLQTopic(topic),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		producerMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	_, _, err := dl.producer.SendMessage(producerMsg)
+	if err != nil {
+		return err
+	}
+	dl.metrics.Counter["messages_dead_lettered"].With(prometheus.Labels{"topic": topic, "reason": reason}).Inc()
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// HeaderEventID is the same in all the copies of the event.
+const HeaderEventID = "event-id"
+
+// EventIDRecordHeader returns the header to be sent with the event.
+func EventIDRecordHeader(id string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(HeaderEventID), Value: []byte(id)}
+}
+
+// EventID returns the event ID of the message or topic/partition/offset without the header.
+func EventID(msg *sarama.ConsumerMessage) string {
+	if id := header(msg, HeaderEventID); id != "" {
+		return id
+	}
+	topic, partition, offset := Source(msg)
+
+	return fmt.Sprintf("%s/%d/%d", topic, partition, offset)
+}
@@ -0,0 +1,136 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of the consumed messages, they are used as the outcome label.
+const (
+	OutcomeOK     = "ok"
+	OutcomeRetry  = "retry"
+	OutcomeDLQ    = "dlq"
+	OutcomeFailed = "failed"
+)
+
+// AddMetrics adds the consumer, retry and dead-letter metrics used by this package.
+func AddMetrics(metrics prom.Metrics, namespace string) {
+	/*
+		# HELP consumer_lag Количество сообщений в партиции после последнего обработанного (до high-water mark)
+		# TYPE consumer_lag gauge
+		consumer_lag{group="order_created_v1", topic="order_created_v1", partition="0"} 3
+	*/
+	consumerLag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "consumer_lag",
+			Help:      "Количество сообщений в партиции после последнего обработанного (до high-water mark)",
+		}, []string{"group", "topic", "partition"})
+	metrics.GaugeVec["consumer_lag"] = consumerLag
+	/*
+		# HELP messages_dead_lettered Количество сообщений, отправленных в dead-letter топик
+		# TYPE messages_dead_lettered counter
+		messages_dead_lettered{topic="order_created_v1", reason="decode_error"} 1
+	*/
+	messagesDeadLettered := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dead_lettered",
+		Help:      "Количество сообщений, отправленных в dead-letter топик",
+	}, []string{"topic", "reason"})
+	metrics.Counter["messages_dead_lettered"] = messagesDeadLettered
+	/*
+		# HELP messages_retried Количество повторных попыток обработки сообщений
+		# TYPE messages_retried counter
+		messages_retried{topic="order_created_v1", reason="db_error", mode="in_process"} 2
+	*/
+	messagesRetried := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_retried",
+		Help:      "Количество повторных попыток обработки сообщений",
+	}, []string{"topic", "reason", "mode"})
+	metrics.Counter["messages_retried"] = messagesRetried
+	/*
+		# HELP consumer_messages_consumed Количество полученных из Kafka сообщений
+		# TYPE consumer_messages_consumed counter
+		consumer_messages_consumed{group="order_created_v1", topic="order_created_v1", partition="0"} 10
+	*/
+	consumerMessagesConsumed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_messages_consumed",
+		Help:      "Количество полученных из Kafka сообщений",
+	}, []string{"group", "topic", "partition"})
+	metrics.Counter["consumer_messages_consumed"] = consumerMessagesConsumed
+	/*
+		# HELP consumer_messages_processed Количество обработанных сообщений по результату обработки
+		# TYPE consumer_messages_processed counter
+		consumer_messages_processed{group="order_created_v1", topic="order_created_v1", partition="0", outcome="dlq", reason="decode_error"} 1
+	*/
+	consumerMessagesProcessed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_messages_processed",
+		Help:      "Количество обработанных сообщений по результату обработки",
+	}, []string{"group", "topic", "partition", "outcome", "reason"})
+	metrics.Counter["consumer_messages_processed"] = consumerMessagesProcessed
+	consumerRebalances := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_rebalances",
+		Help:      "Количество ребалансировок группы потребителей",
+	}, []string{"group"})
+	metrics.Counter["consumer_rebalances"] = consumerRebalances
+
+	consumerProcessingDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "consumer_processing_duration_seconds",
+			Help:      "Продолжительность обработки сообщения из Kafka",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"group", "topic", "partition"})
+	metrics.Histogram["consumer_processing_duration_seconds"] = consumerProcessingDurationSeconds
+}
+
+// claimMetrics records the consumer metrics of one partition claim.
+type claimMetrics struct {
+	metrics prom.Metrics
+	labels  prometheus.Labels
+	claim   sarama.ConsumerGroupClaim
+}
+
+func newClaimMetrics(metrics prom.Metrics, group string, claim sarama.ConsumerGroupClaim) claimMetrics {
+	return claimMetrics{
+		metrics: metrics,
+		labels:  partitionLabels(group, claim.Topic(), claim.Partition()),
+		claim:   claim,
+	}
+}
+
+func (cm claimMetrics) consumed() {
+	cm.metrics.Counter["consumer_messages_consumed"].With(cm.labels).Inc()
+}
+
+func (cm claimMetrics) processed(outcome, reason string, duration time.Duration) {
+	cm.metrics.Histogram["consumer_processing_duration_seconds"].With(cm.labels).Observe(duration.Seconds())
+	cm.metrics.Counter["consumer_messages_processed"].With(prometheus.Labels{
+		"group":     cm.labels["group"],
+		"topic":     cm.labels["topic"],
+		"partition": cm.labels["partition"],
+		"outcome":   outcome,
+		"reason":    reason,
+	}).Inc()
+}
+
+// marked sets the lag: the number of messages after msg up to the high-water mark of the partition.
+func (cm claimMetrics) marked(msg *sarama.ConsumerMessage) {
+	lag := cm.claim.HighWaterMarkOffset() - msg.Offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	cm.metrics.GaugeVec["consumer_lag"].With(cm.labels).Set(float64(lag))
+}
+
+func partitionLabels(group, topic string, partition int32) prometheus.Labels {
+	return prometheus.Labels{"group": group, "topic": topic, "partition": strconv.FormatInt(int64(partition), 10)}
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// InitProducer returns the producer and its client, the client is closed after the producer.
+func InitProducer(clientCfg ClientConfig) (sarama.SyncProducer, sarama.Client) {
+	brokerCfg := sarama.NewConfig()
+	clientCfg.apply(brokerCfg)
+	brokerCfg.Producer.RequiredAcks = sarama.WaitForAll
+	brokerCfg.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(clientCfg.Brokers, brokerCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Kafka error.")
+		os.Exit(1)
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Kafka error.")
+		os.Exit(1)
+	}
+
+	return producer, client
+}
+
+// ProducerCheck reports whether the producer client is able to reach the cluster.
+func ProducerCheck(client sarama.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if client.Closed() {
+			return errors.New("kafka client is closed")
+		}
+
+		return client.RefreshMetadata()
+	}
+}
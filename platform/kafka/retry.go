@@ -0,0 +1,381 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/kybuk_oo/example_go_metrics/platform/config"
+	"github.com/kybuk_oo/example_go_metrics/platform/events"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/kybuk_oo/example_go_metrics/platform/requestid"
+	"github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ReasonProcessError = "process_error"
+	ReasonCancelled    = "cancelled"
+
+	// HeaderRetryAttempt holds the number of attempts made before the message was sent to a retry topic.
+	HeaderRetryAttempt = "retry-attempt"
+	// HeaderRetrySourceTopic holds the topic the message was originally consumed from.
+	HeaderRetrySourceTopic = "retry-source-topic"
+	// HeaderRetrySourcePartition and HeaderRetrySourceOffset hold the position of the message in that topic.
+	HeaderRetrySourcePartition = "retry-source-partition"
+	HeaderRetrySourceOffset    = "retry-source-offset"
+	// HeaderRetryNotBefore holds the time (unix ms) before which the retry topic consumer doesn't process the message.
+	HeaderRetryNotBefore = "retry-not-before"
+
+	retryTopicInfix = ".retry."
+
+	defaultMaxAttempts     = 3
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// MessageHandler processes one message, the Permanent errors aren't retried.
+type MessageHandler interface {
+	Handle(ctx context.Context, msg *sarama.ConsumerMessage) error
+}
+
+// HandlerError is the error of MessageHandler with the reason used in the metrics and the DLQ headers.
+type HandlerError struct {
+	Reason    string
+	Permanent bool
+	Err       error
+}
+
+func (e HandlerError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks the error as not worth retrying, e.g. a malformed payload.
+func Permanent(reason string, err error) error {
+	return HandlerError{Reason: reason, Permanent: true, Err: err}
+}
+
+// Retryable marks the error as transient, e.g. a lost DB connection.
+func Retryable(reason string, err error) error {
+	return HandlerError{Reason: reason, Err: err}
+}
+
+// DecodeError marks the failure to decode the payload as permanent.
+func DecodeError(err error) error {
+	var validationErr events.ValidationError
+	if errors.As(err, &validationErr) {
+		return Permanent(ReasonSchemaError, err)
+	}
+
+	return Permanent(ReasonDecodeError, err)
+}
+
+// RetryPolicy defines the in-process attempts and the delays of the retry topics.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Delays of the retry topics <topic>.retry.1, <topic>.retry.2, ...
+	Delays []time.Duration
+}
+
+// NewRetryPolicy reads the CONSUMER_MAX_ATTEMPTS and CONSUMER_RETRY_* settings.
+func NewRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: config.Int("CONSUMER_MAX_ATTEMPTS", defaultMaxAttempts, 1),
+		Backoff:     config.Duration("CONSUMER_RETRY_BACKOFF", defaultRetryBackoff),
+		MaxBackoff:  config.Duration("CONSUMER_RETRY_MAX_BACKOFF", defaultRetryMaxBackoff),
+		Delays:      config.Durations("CONSUMER_RETRY_DELAYS", nil),
+	}
+}
+
+// RetryTopic returns the retry topic of the stage (1-based).
+func RetryTopic(topic string, stage int) string {
+	return topic + retryTopicInfix + strconv.Itoa(stage)
+}
+
+// backoff returns the jittered exponential delay before the next in-process attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.Backoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	half := int64(backoff / 2)
+
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// BuildRetryHandlers returns the consumers of the source topics and of their retry topics.
+func BuildRetryHandlers(handlers map[string]MessageHandler, policy RetryPolicy, producer sarama.SyncProducer, metrics prom.Metrics) (map[string]Consumer, error) {
+	deadLetter := NewDeadLetter(producer, metrics)
+	result := make(map[string]Consumer, len(handlers)*(len(policy.Delays)+1))
+	for topic, handler := range handlers {
+		cfg, err := LoadConsumerConfig(topic)
+		if err != nil {
+			return nil, err
+		}
+		for stage := 0; stage <= len(policy.Delays); stage++ {
+			consumedTopic := topic
+			if stage > 0 {
+				consumedTopic = RetryTopic(topic, stage)
+			}
+			stageCfg := cfg.forRetryStage(stage)
+			result[consumedTopic] = Consumer{
+				Handler: RetryHandler{
+					group:      stageCfg.GroupID,
+					topic:      topic,
+					stage:      stage,
+					handler:    handler,
+					policy:     policy,
+					producer:   producer,
+					deadLetter: deadLetter,
+					metrics:    metrics,
+				},
+				Config: stageCfg,
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// RetryHandler consumes the source topic (stage 0) or one of its retry topics.
+type RetryHandler struct {
+	group      string
+	topic      string
+	stage      int
+	handler    MessageHandler
+	policy     RetryPolicy
+	producer   sarama.SyncProducer
+	deadLetter DeadLetter
+	metrics    prom.Metrics
+}
+
+// ConsumeClaim ends the session when a message can't be retried or dead-lettered.
+func (rh RetryHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	metrics := newClaimMetrics(rh.metrics, rh.group, claim)
+	for msg := range claim.Messages() {
+		metrics.consumed()
+		start := time.Now()
+		outcome, reason, err := rh.process(session.Context(), msg)
+		metrics.processed(outcome, reason, time.Since(start))
+		if err != nil {
+			return err
+		}
+		session.MarkMessage(msg, "")
+		metrics.marked(msg)
+	}
+
+	return nil
+}
+
+// process runs the handler in a consumer span and records the outcome.
+func (rh RetryHandler) process(ctx context.Context, msg *sarama.ConsumerMessage) (outcome, reason string, err error) {
+	ctx = requestid.NewContext(ctx, requestid.FromMessage(msg))
+	ctx, span := tracing.Tracer().Start(tracing.ExtractMessage(ctx, msg), msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(msg.Topic),
+			semconv.MessagingKafkaPartitionKey.Int64(int64(msg.Partition)),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+	ctx = tracing.WithLogger(ctx, requestid.Logger(ctx))
+	if rh.stage > 0 {
+		err = waitUntil(ctx, notBefore(msg))
+		if err != nil {
+			return OutcomeFailed, ReasonCancelled, err
+		}
+	}
+
+	previousAttempts := retryAttempt(msg)
+	attempts, err := rh.handle(ctx, msg)
+	if err == nil {
+		return OutcomeOK, "", nil
+	}
+	if ctx.Err() != nil {
+		return OutcomeFailed, ReasonCancelled, ctx.Err()
+	}
+
+	reason, permanent := classify(err)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, reason)
+	requestid.Logger(ctx).Error().Err(err).Str("topic", msg.Topic).Int32("partition", msg.Partition).Int64("offset", msg.Offset).
+		Int("attempt", previousAttempts+attempts).Msg("Event hasn't been handled.")
+
+	if !permanent && rh.stage < len(rh.policy.Delays) {
+		err = rh.sendToRetryTopic(msg, previousAttempts+attempts)
+		if err != nil {
+			return OutcomeFailed, reason, fmt.Errorf("message %s/%d/%d hasn't been sent to the retry topic: %w", msg.Topic, msg.Partition, msg.Offset, err)
+		}
+		rh.metrics.Counter["messages_retried"].With(prometheus.Labels{"topic": rh.topic, "reason": reason, "mode": "delayed"}).Inc()
+		return OutcomeRetry, reason, nil
+	}
+
+	err = rh.deadLetter.Send(msg, reason, err, previousAttempts+attempts)
+	if err != nil {
+		return OutcomeFailed, reason, fmt.Errorf("message %s/%d/%d hasn't been dead-lettered: %w", msg.Topic, msg.Partition, msg.Offset, err)
+	}
+
+	return OutcomeDLQ, reason, nil
+}
+
+// handle runs the handler up to MaxAttempts times and returns the number of attempts made.
+func (rh RetryHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) (int, error) {
+	attempt := 1
+	for ; ; attempt++ {
+		err := rh.handler.Handle(ctx, msg)
+		if err == nil {
+			return attempt, nil
+		}
+		reason, permanent := classify(err)
+		if permanent || attempt >= rh.policy.MaxAttempts {
+			return attempt, err
+		}
+
+		rh.metrics.Counter["messages_retried"].With(prometheus.Labels{"topic": rh.topic, "reason": reason, "mode": "in_process"}).Inc()
+		err = waitUntil(ctx, time.Now().Add(rh.policy.backoff(attempt)))
+		if err != nil {
+			return attempt, err
+		}
+	}
+}
+
+func (rh RetryHandler) sendToRetryTopic(msg *sarama.ConsumerMessage, attempts int) error {
+	stage := rh.stage + 1
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, header := range msg.Headers {
+		if header == nil {
+			continue
+		}
+		switch string(header.Key) {
+		case HeaderRetryAttempt, HeaderRetrySourceTopic, HeaderRetrySourcePartition, HeaderRetrySourceOffset, HeaderRetryNotBefore:
+		default:
+			headers = append(headers, *header)
+		}
+	}
+	_, partition, offset := Source(msg)
+	notBefore := time.Now().Add(rh.policy.Delays[stage-1])
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderRetryAttempt), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte(HeaderRetrySourceTopic), Value: []byte(rh.topic)},
+		sarama.RecordHeader{Key: []byte(HeaderRetrySourcePartition), Value: []byte(strconv.FormatInt(int64(partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderRetrySourceOffset), Value: []byte(strconv.FormatInt(offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderRetryNotBefore), Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))},
+	)
+
+	producerMsg := &sarama.ProducerMessage{
+		Topic:   RetryTopic(rh.topic, stage),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		producerMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+	_, _, err := rh.producer.SendMessage(producerMsg)
+
+	return err
+}
+
+// Setup counts the rebalance: a new session starts after every rebalance of the group.
+func (rh RetryHandler) Setup(sarama.ConsumerGroupSession) error {
+	rh.metrics.Counter["consumer_rebalances"].With(prometheus.Labels{"group": rh.group}).Inc()
+
+	return nil
+}
+
+// Cleanup drops the lag of the partitions of the ended session, they may be claimed by another member.
+func (rh RetryHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			rh.metrics.GaugeVec["consumer_lag"].Delete(partitionLabels(rh.group, topic, partition))
+		}
+	}
+
+	return nil
+}
+
+// classify returns the reason of the error and whether it is permanent.
+func classify(err error) (string, bool) {
+	var handlerErr HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr.Reason, handlerErr.Permanent
+	}
+
+	return ReasonProcessError, false
+}
+
+// sourceTopic returns the topic the message was originally consumed from.
+func sourceTopic(msg *sarama.ConsumerMessage) string {
+	if topic := header(msg, HeaderRetrySourceTopic); topic != "" {
+		return topic
+	}
+
+	return msg.Topic
+}
+
+// Source returns the position of the message in its source topic.
+func Source(msg *sarama.ConsumerMessage) (topic string, partition int32, offset int64) {
+	topic = header(msg, HeaderRetrySourceTopic)
+	p, partitionErr := strconv.ParseInt(header(msg, HeaderRetrySourcePartition), 10, 32)
+	offset, offsetErr := strconv.ParseInt(header(msg, HeaderRetrySourceOffset), 10, 64)
+	if topic == "" || partitionErr != nil || offsetErr != nil {
+		return msg.Topic, msg.Partition, msg.Offset
+	}
+
+	return topic, int32(p), offset
+}
+
+func retryAttempt(msg *sarama.ConsumerMessage) int {
+	attempt, _ := strconv.Atoi(header(msg, HeaderRetryAttempt))
+
+	return attempt
+}
+
+func notBefore(msg *sarama.ConsumerMessage) time.Time {
+	ms, err := strconv.ParseInt(header(msg, HeaderRetryNotBefore), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
+
+func header(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+// waitUntil sleeps until t or until ctx is done.
+func waitUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,236 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 200 * time.Millisecond, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 200 * time.Millisecond},
+		{attempt: 2, max: 400 * time.Millisecond},
+		{attempt: 5, max: 3200 * time.Millisecond},
+		{attempt: 6, max: 5 * time.Second},
+		// The shift overflows, the delay is still capped.
+		{attempt: 100, max: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			backoff := policy.backoff(tt.attempt)
+			if backoff < tt.max/2 || backoff > tt.max {
+				t.Fatalf("backoff of attempt %d = %s, it has to be within [%s, %s]", tt.attempt, backoff, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func recordHeaders(headers map[string]string) []*sarama.RecordHeader {
+	var records []*sarama.RecordHeader
+	for key, value := range headers {
+		records = append(records, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+
+	return records
+}
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		wantTopic     string
+		wantPartition int32
+		wantOffset    int64
+	}{
+		{name: "source message", wantTopic: "orders", wantPartition: 2, wantOffset: 40},
+		{
+			name:      "retry copy",
+			headers:   map[string]string{HeaderRetrySourceTopic: "orders", HeaderRetrySourcePartition: "1", HeaderRetrySourceOffset: "7"},
+			wantTopic: "orders", wantPartition: 1, wantOffset: 7,
+		},
+		{
+			name:      "invalid partition",
+			headers:   map[string]string{HeaderRetrySourceTopic: "orders", HeaderRetrySourcePartition: "one", HeaderRetrySourceOffset: "7"},
+			wantTopic: "orders", wantPartition: 2, wantOffset: 40,
+		},
+		{
+			name:      "no offset",
+			headers:   map[string]string{HeaderRetrySourceTopic: "orders", HeaderRetrySourcePartition: "1"},
+			wantTopic: "orders", wantPartition: 2, wantOffset: 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: 40, Headers: recordHeaders(tt.headers)}
+
+			topic, partition, offset := Source(msg)
+			if topic != tt.wantTopic || partition != tt.wantPartition || offset != tt.wantOffset {
+				t.Fatalf("Source = %s/%d/%d, %s/%d/%d is expected", topic, partition, offset, tt.wantTopic, tt.wantPartition, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestRetryHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		wantAttempt   int
+		wantNotBefore time.Time
+	}{
+		{name: "no headers"},
+		{name: "valid", headers: map[string]string{HeaderRetryAttempt: "3", HeaderRetryNotBefore: "1682935200000"}, wantAttempt: 3, wantNotBefore: time.UnixMilli(1682935200000)},
+		{name: "invalid", headers: map[string]string{HeaderRetryAttempt: "three", HeaderRetryNotBefore: "soon"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Headers: recordHeaders(tt.headers)}
+			if attempt := retryAttempt(msg); attempt != tt.wantAttempt {
+				t.Fatalf("retryAttempt = %d, %d is expected", attempt, tt.wantAttempt)
+			}
+			if at := notBefore(msg); !at.Equal(tt.wantNotBefore) {
+				t.Fatalf("notBefore = %s, %s is expected", at, tt.wantNotBefore)
+			}
+		})
+	}
+}
+
+func TestEventID(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: 40}
+	if id := EventID(msg); id != "orders/2/40" {
+		t.Fatalf("EventID without the header = %s, orders/2/40 is expected", id)
+	}
+
+	msg.Headers = recordHeaders(map[string]string{HeaderEventID: "order_created:1"})
+	if id := EventID(msg); id != "order_created:1" {
+		t.Fatalf("EventID = %s, order_created:1 is expected", id)
+	}
+}
+
+// failingHandler fails the first failures calls with err.
+type failingHandler struct {
+	failures int
+	err      error
+	calls    *int
+}
+
+func (h failingHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	*h.calls++
+	if *h.calls <= h.failures {
+		return h.err
+	}
+
+	return nil
+}
+
+// expectSent expects the message to be sent to the topic with the header.
+func expectSent(producer *mocks.SyncProducer, topic, key, value string) {
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != topic {
+			return fmt.Errorf("message has been sent to %s, %s is expected", msg.Topic, topic)
+		}
+		for _, header := range msg.Headers {
+			if string(header.Key) == key && string(header.Value) == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("header %s=%s is expected in %v", key, value, msg.Headers)
+	})
+}
+
+func TestRetryHandlerProcess(t *testing.T) {
+	errDB := Retryable(ReasonDBError, errors.New("connection refused"))
+	errDecode := DecodeError(errors.New("unexpected end of JSON input"))
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Delays: []time.Duration{time.Millisecond}}
+
+	tests := []struct {
+		name      string
+		stage     int
+		failures  int
+		err       error
+		headers   map[string]string
+		expect    func(producer *mocks.SyncProducer)
+		wantCalls int
+		want      string
+		wantErr   bool
+	}{
+		{name: "handled", wantCalls: 1, want: OutcomeOK},
+		{name: "handled after in-process retries", failures: 2, err: errDB, wantCalls: 3, want: OutcomeOK},
+		{
+			name: "permanent error is dead-lettered at once", failures: 1, err: errDecode, wantCalls: 1, want: OutcomeDLQ,
+			expect: func(producer *mocks.SyncProducer) {
+				expectSent(producer, "orders.dlq", HeaderDLQReason, ReasonDecodeError)
+			},
+		},
+		{
+			name: "retryable error goes to the retry topic", failures: 3, err: errDB, wantCalls: 3, want: OutcomeRetry,
+			expect: func(producer *mocks.SyncProducer) {
+				expectSent(producer, "orders.retry.1", HeaderRetryAttempt, "3")
+			},
+		},
+		{
+			name: "last retry topic dead-letters into the source DLQ", stage: 1, failures: 3, err: errDB, wantCalls: 3, want: OutcomeDLQ,
+			headers: map[string]string{HeaderRetryAttempt: "3", HeaderRetrySourceTopic: "orders", HeaderRetrySourcePartition: "0", HeaderRetrySourceOffset: "7"},
+			expect: func(producer *mocks.SyncProducer) {
+				expectSent(producer, "orders.dlq", HeaderDLQAttempt, "6")
+			},
+		},
+		{
+			name: "failed dead-lettering isn't marked", failures: 1, err: errDecode, wantCalls: 1, want: OutcomeFailed, wantErr: true,
+			expect: func(producer *mocks.SyncProducer) {
+				producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := mocks.NewSyncProducer(t, nil)
+			defer producer.Close()
+			if tt.expect != nil {
+				tt.expect(producer)
+			}
+			metrics := prom.NewMetrics()
+			AddMetrics(metrics, "test")
+			calls := 0
+			rh := RetryHandler{
+				group:      "orders",
+				topic:      "orders",
+				stage:      tt.stage,
+				handler:    failingHandler{failures: tt.failures, err: tt.err, calls: &calls},
+				policy:     policy,
+				producer:   producer,
+				deadLetter: NewDeadLetter(producer, metrics),
+				metrics:    metrics,
+			}
+			msg := &sarama.ConsumerMessage{Topic: "orders", Key: []byte("1"), Value: []byte(`{}`), Headers: recordHeaders(tt.headers)}
+			if tt.stage > 0 {
+				msg.Topic = RetryTopic("orders", tt.stage)
+			}
+
+			outcome, _, err := rh.process(context.Background(), msg)
+			if outcome != tt.want || (err != nil) != tt.wantErr || calls != tt.wantCalls {
+				t.Fatalf("process = %s, %v after %d calls, %s after %d calls is expected", outcome, err, calls, tt.want, tt.wantCalls)
+			}
+			inProcess := testutil.ToFloat64(metrics.Counter["messages_retried"].With(prometheus.Labels{"topic": "orders", "reason": ReasonDBError, "mode": "in_process"}))
+			if tt.err == errDB && int(inProcess) != tt.wantCalls-1 {
+				t.Fatalf("%v in-process retries are counted, %d are expected", inProcess, tt.wantCalls-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Package prom keeps the Prometheus metrics of a service by name and serves them on /metrics.
+package prom
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	Counter   map[string]*prometheus.CounterVec
+	Gauge     map[string]prometheus.Gauge
+	GaugeVec  map[string]*prometheus.GaugeVec
+	Summary   map[string]prometheus.Summary
+	Histogram map[string]*prometheus.HistogramVec
+
+	server *http.Server
+}
+
+func NewMetrics() Metrics {
+	return Metrics{
+		Counter:   make(map[string]*prometheus.CounterVec),
+		Gauge:     make(map[string]prometheus.Gauge),
+		GaugeVec:  make(map[string]*prometheus.GaugeVec),
+		Summary:   make(map[string]prometheus.Summary),
+		Histogram: make(map[string]*prometheus.HistogramVec),
+	}
+}
+
+// RunPrometheus registers all the metrics and serves them on /metrics on METRICS_PORT.
+func RunPrometheus(metrics Metrics) (Metrics, error) {
+	fmt.Println("start server metrics...")
+
+	var collectors []prometheus.Collector
+	for _, counter := range metrics.Counter {
+		collectors = append(collectors, counter)
+	}
+	for _, gauge := range metrics.Gauge {
+		collectors = append(collectors, gauge)
+	}
+	for _, gaugeVec := range metrics.GaugeVec {
+		collectors = append(collectors, gaugeVec)
+	}
+	for _, summary := range metrics.Summary {
+		collectors = append(collectors, summary)
+	}
+	for _, histogram := range metrics.Histogram {
+		collectors = append(collectors, histogram)
+	}
+	for _, collector := range collectors {
+		err := prometheus.Register(collector)
+		if err != nil {
+			return Metrics{}, err
+		}
+	}
+
+	http.Handle("/metrics", promhttp.Handler())
+	metrics.server = &http.Server{Addr: ":" + os.Getenv("METRICS_PORT")}
+	go func(server *http.Server) {
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}(metrics.server)
+
+	return metrics, nil
+}
+
+// Shutdown stops the metrics server.
+func (m Metrics) Shutdown(ctx context.Context) error {
+	if m.server == nil {
+		return nil
+	}
+
+	return m.server.Shutdown(ctx)
+}
@@ -0,0 +1,88 @@
+// Package requestid carries the correlation ID of a request through HTTP, Kafka and the logs.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// HTTPHeader is the HTTP header of the request ID.
+	HTTPHeader = "X-Request-ID"
+	// KafkaHeader is the Kafka record header of the request ID.
+	KafkaHeader = "request-id"
+
+	maxLength = 128
+)
+
+type ctxKey struct{}
+
+// New generates a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Error().Err(err).Msg("Request ID hasn't been generated.")
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// KafkaRecordHeader returns the Kafka record header carrying the request ID of ctx.
+func KafkaRecordHeader(ctx context.Context) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(KafkaHeader), Value: []byte(FromContext(ctx))}
+}
+
+// NewContext returns a copy of ctx carrying the request ID and a logger with the request_id field.
+func NewContext(ctx context.Context, id string) context.Context {
+	logger := log.With().Str("request_id", id).Logger()
+	ctx = logger.WithContext(ctx)
+
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID of ctx or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
+
+// Logger returns the logger of ctx with the request_id field or the global logger.
+func Logger(ctx context.Context) *zerolog.Logger {
+	if FromContext(ctx) == "" {
+		return &log.Logger
+	}
+
+	return zerolog.Ctx(ctx)
+}
+
+// FromMessage returns the request ID of the consumed message or a new one, if the producer hasn't set it.
+func FromMessage(msg *sarama.ConsumerMessage) string {
+	for _, header := range msg.Headers {
+		if header != nil && string(header.Key) == KafkaHeader && len(header.Value) > 0 {
+			return string(header.Value)
+		}
+	}
+
+	return New()
+}
+
+// Middleware puts the request ID into the request context and the response headers.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HTTPHeader)
+		if id == "" || len(id) > maxLength {
+			id = New()
+		}
+
+		w.Header().Set(HTTPHeader, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
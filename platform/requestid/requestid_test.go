@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		wantKept bool
+	}{
+		{name: "supplied", id: "req-1", wantKept: true},
+		{name: "missing"},
+		{name: "max length", id: strings.Repeat("a", maxLength), wantKept: true},
+		{name: "too long", id: strings.Repeat("a", maxLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			if tt.id != "" {
+				r.Header.Set(HTTPHeader, tt.id)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(HTTPHeader)
+			if id != ctxID {
+				t.Fatalf("response ID %s differs from the context one %s", id, ctxID)
+			}
+			if tt.wantKept && id != tt.id {
+				t.Fatalf("ID %s, the supplied %s is expected", id, tt.id)
+			}
+			if !tt.wantKept && (id == tt.id || id == "" || len(id) > maxLength) {
+				t.Fatalf("ID %q, a new one is expected", id)
+			}
+		})
+	}
+}
+
+func TestFromMessage(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{{Key: []byte(KafkaHeader), Value: []byte("req-1")}}}
+	if id := FromMessage(msg); id != "req-1" {
+		t.Fatalf("FromMessage = %s, req-1 is expected", id)
+	}
+
+	if id := FromMessage(&sarama.ConsumerMessage{}); id == "" {
+		t.Fatal("FromMessage without the header has to generate an ID")
+	}
+}
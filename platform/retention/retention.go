@@ -0,0 +1,90 @@
+// Package retention deletes the rows the services don't need anymore.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// batchSize limits the rows deleted by one statement.
+const batchSize = 1000
+
+// Policy deletes the rows of Table whose Column is older than TTL, Table and Column have to be constants.
+type Policy struct {
+	Table  string
+	Column string
+	TTL    time.Duration
+}
+
+type Cleaner struct {
+	db       *pgxpool.Pool
+	metrics  prom.Metrics
+	policies []Policy
+}
+
+// AddMetrics adds the retention_deleted_rows counter used by the Cleaner.
+func AddMetrics(metrics prom.Metrics, namespace string) {
+	/*
+		# HELP retention_deleted_rows Количество строк, удалённых по истечении срока хранения
+		# TYPE retention_deleted_rows counter
+		retention_deleted_rows{table="inbox"} 1000
+	*/
+	retentionDeletedRows := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_deleted_rows",
+		Help:      "Количество строк, удалённых по истечении срока хранения",
+	}, []string{"table"})
+	metrics.Counter["retention_deleted_rows"] = retentionDeletedRows
+}
+
+func NewCleaner(db *pgxpool.Pool, metrics prom.Metrics, policies ...Policy) Cleaner {
+	return Cleaner{db: db, metrics: metrics, policies: policies}
+}
+
+// Run applies the policies every interval until ctx is done.
+func (c Cleaner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, policy := range c.policies {
+			_, err := c.Purge(ctx, policy)
+			if err != nil {
+				log.Error().Err(err).Str("table", policy.Table).Msg("Expired rows haven't been deleted.")
+			}
+		}
+	}
+}
+
+// Purge deletes the expired rows of the policy in batches and returns their number.
+func (c Cleaner) Purge(ctx context.Context, policy Policy) (int64, error) {
+	query := fmt.Sprintf(`
+		DELETE FROM %[1]s WHERE ctid = ANY(ARRAY(
+			SELECT ctid FROM %[1]s WHERE %[2]s < NOW() - $1 * INTERVAL '1 second' LIMIT $2
+		))`, policy.Table, policy.Column)
+
+	var deleted int64
+	for {
+		tag, err := c.db.Exec(ctx, query, int64(policy.TTL.Seconds()), batchSize)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += tag.RowsAffected()
+		c.metrics.Counter["retention_deleted_rows"].With(prometheus.Labels{"table": policy.Table}).Add(float64(tag.RowsAffected()))
+		if tag.RowsAffected() < batchSize {
+			return deleted, nil
+		}
+	}
+}
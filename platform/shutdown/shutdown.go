@@ -0,0 +1,87 @@
+// Package shutdown stops a service gracefully.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kybuk_oo/example_go_metrics/platform/kafka"
+	"github.com/kybuk_oo/example_go_metrics/platform/prom"
+	"github.com/rs/zerolog/log"
+)
+
+// Server is the HTTP server of the service.
+type Server interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Service lists the parts stopped by Run.
+type Service struct {
+	Server    Server
+	Consumers kafka.Consumers
+	// Background tracks the jobs running until the service context is cancelled.
+	Background  *sync.WaitGroup
+	Metrics     prom.Metrics
+	Tracing     func(context.Context) error
+	Producer    sarama.SyncProducer
+	KafkaClient sarama.Client
+	DB          *pgxpool.Pool
+}
+
+// Run stops the parts of the service in the order they depend on each other.
+func (s Service) Run(ctx context.Context) {
+	err := s.Server.Shutdown(ctx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("Server hasn't been stopped gracefully.")
+	}
+
+	err = s.Consumers.Wait(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Consumers haven't been stopped gracefully.")
+	}
+
+	err = wait(ctx, s.Background)
+	if err != nil {
+		log.Error().Err(err).Msg("Background jobs haven't been stopped gracefully.")
+	}
+
+	err = s.Metrics.Shutdown(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Server metrics hasn't been stopped gracefully.")
+	}
+
+	err = s.Tracing(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Tracing hasn't been stopped gracefully.")
+	}
+
+	err = s.Producer.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("Kafka producer hasn't been closed.")
+	}
+	err = s.KafkaClient.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("Kafka client hasn't been closed.")
+	}
+
+	s.DB.Close()
+}
+
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+// Package tracing configures OpenTelemetry and propagates the W3C trace context through HTTP and Kafka.
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+
+	instrumentationName = "github.com/kybuk_oo/example_go_metrics/platform/tracing"
+	defaultOTLPEndpoint = "localhost:4318"
+	defaultFile         = "traces.json"
+)
+
+// Init sets up the TRACING_EXPORTER exporter and returns the function flushing the spans on shutdown.
+func Init(ctx context.Context, serviceName string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closer, err := newExporter(ctx)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closeErr := closer.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context) (sdktrace.SpanExporter, io.Closer, error) {
+	switch exporter := os.Getenv("TRACING_EXPORTER"); exporter {
+	case "", ExporterNone:
+		return nil, nil, nil
+	case ExporterOTLP:
+		endpoint := os.Getenv("TRACING_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if insecure, _ := strconv.ParseBool(os.Getenv("TRACING_OTLP_INSECURE")); insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		spanExporter, err := otlptracehttp.New(ctx, opts...)
+		return spanExporter, nil, err
+	case ExporterStdout:
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return spanExporter, nil, err
+	case ExporterFile:
+		path := os.Getenv("TRACING_FILE")
+		if path == "" {
+			path = defaultFile
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		return spanExporter, file, err
+	default:
+		log.Error().Str("value", exporter).Msg("Invalid TRACING_EXPORTER, tracing is disabled.")
+		return nil, nil, nil
+	}
+}
+
+// Tracer returns the tracer of the service.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// WithLogger adds trace_id and span_id to the logger of ctx.
+func WithLogger(ctx context.Context, logger *zerolog.Logger) context.Context {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return logger.WithContext(ctx)
+	}
+
+	l := logger.With().
+		Str("trace_id", spanContext.TraceID().String()).
+		Str("span_id", spanContext.SpanID().String()).
+		Logger()
+
+	return l.WithContext(ctx)
+}
+
+// End records err in the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}